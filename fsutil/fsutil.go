@@ -82,7 +82,7 @@ func GetFile(filename string) (*os.File, error) {
 	file := filename
 	if !FileExists(file) {
 		errMsg := fmt.Sprintf("File %s does not exist", file)
-		return nil, ansi.Errorf(errMsg)
+		return nil, ansi.Errorf("%s", errMsg)
 	}
 	return os.Open(file)
 }
@@ -0,0 +1,53 @@
+package ipc
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+// Codec is the wire format of the IPC messages on a connection.
+// Both ends of a connection must use the same codec.
+//
+// Available codecs:
+//   - GobCodec: encoding/gob, the default
+//   - FrameCodec: the versioned, length-prefixed binary framing described in frame.go
+type Codec interface {
+	Name() string                   // Name of the codec, for logging
+	NewEncoder(w io.Writer) Encoder // Encoder for the life of a connection
+	NewDecoder(r io.Reader) Decoder // Decoder for the life of a connection
+}
+
+// Encoder writes IPC messages to a connection
+type Encoder interface {
+	Encode(msg *IPCRequest) error
+}
+
+// Decoder reads IPC messages from a connection
+type Decoder interface {
+	Decode(msg *IPCRequest) error
+}
+
+var (
+	GobCodec   Codec = gobCodec{}
+	FrameCodec Codec = frameCodec{}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gobEncoder{gob.NewEncoder(w)}
+}
+
+func (gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gobDecoder{gob.NewDecoder(r)}
+}
+
+type gobEncoder struct{ enc *gob.Encoder }
+
+func (e gobEncoder) Encode(msg *IPCRequest) error { return e.enc.Encode(msg) }
+
+type gobDecoder struct{ dec *gob.Decoder }
+
+func (d gobDecoder) Decode(msg *IPCRequest) error { return d.dec.Decode(msg) }
@@ -0,0 +1,286 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// DataCodec encodes and decodes the data of the messages of a datatype
+type DataCodec interface {
+	Name() string                       // Name of the datatype, for logging
+	Marshal(v any) ([]byte, error)      // Encodes the value as the data of a message
+	Unmarshal(data []byte, v any) error // Decodes the data of a message into the value pointed to by v
+}
+
+// dataCodecs is the registry of the datatypes, shared by the clients and the servers of the process
+var dataCodecs = struct {
+	sync.RWMutex
+	m map[DataType]DataCodec
+}{m: map[DataType]DataCodec{
+	DATA_TEXT:    textCodec{},
+	DATA_INT:     intCodec{},
+	DATA_JSON:    jsonCodec{},
+	DATA_YAML:    yamlCodec{},
+	DATA_BIN:     binCodec{},
+	DATA_MSGPACK: msgpackCodec{},
+	DATA_CBOR:    newCBORCodec(),
+}}
+
+// RegisterDataCodec registers the codec of a new datatype, to be used by EncodeData, DecodeData and the clients.
+// Both ends of a connection must register the same codec with the same id. The ids from DATA_CUSTOM up
+// are left to applications, the lower ones are reserved for the package.
+// It panics if the id is already registered, or doesn't fit in the byte of the frame header.
+func RegisterDataCodec(dataType DataType, c DataCodec) {
+	if c == nil {
+		panic("ipc: nil data codec")
+	}
+	if dataType <= 0 || dataType > 0xFF {
+		panic(fmt.Sprintf("ipc: datatype 0x%02x out of range", int(dataType)))
+	}
+
+	dataCodecs.Lock()
+	defer dataCodecs.Unlock()
+	if prev, ok := dataCodecs.m[dataType]; ok {
+		panic(fmt.Sprintf("ipc: datatype 0x%02x already registered for %s", int(dataType), prev.Name()))
+	}
+	dataCodecs.m[dataType] = c
+}
+
+// DataCodecFor returns the codec of the datatype, if it is registered
+func DataCodecFor(dataType DataType) (DataCodec, bool) {
+	dataCodecs.RLock()
+	defer dataCodecs.RUnlock()
+	c, ok := dataCodecs.m[dataType]
+	return c, ok
+}
+
+// MarshalData encodes the value as the data of a message of the datatype, with its registered codec
+func MarshalData(dataType DataType, v any) ([]byte, error) {
+	c, ok := DataCodecFor(dataType)
+	if !ok {
+		return nil, fmt.Errorf("ipc: encode %T: no codec for datatype 0x%02x", v, int(dataType))
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("ipc: encode %T as %s: %w", v, c.Name(), err)
+	}
+	return data, nil
+}
+
+// EncodeData encodes the value as the data of a message, and returns the datatype it picked:
+// DATA_TEXT for strings, DATA_BIN for byte slices, DATA_INT for integers, and DATA_JSON for anything else.
+// Use MarshalData for the other datatypes.
+func EncodeData(v any) (DataType, []byte, error) {
+	var dataType DataType = DATA_JSON
+	switch v.(type) {
+	case string:
+		dataType = DATA_TEXT
+	case []byte:
+		dataType = DATA_BIN
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		dataType = DATA_INT
+	}
+
+	data, err := MarshalData(dataType, v)
+	if err != nil {
+		return 0, nil, err
+	}
+	return dataType, data, nil
+}
+
+// DecodeData decodes the data of a message of the datatype into the value pointed to by v, with the registered codec.
+// Text is decoded into strings and encoding.TextUnmarshaler implementations, integers into any integer type,
+// and every datatype into an *any.
+func DecodeData(dataType DataType, data []byte, v any) error {
+	c, ok := DataCodecFor(dataType)
+	if !ok {
+		return fmt.Errorf("%w: no codec for datatype 0x%02x", ErrDecode, int(dataType))
+	}
+	if err := c.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s into %T: %v", ErrDecode, c.Name(), v, err)
+	}
+	return nil
+}
+
+// Decode decodes the data of the message into the value pointed to by v, see DecodeData
+func (m IPCMessage) Decode(v any) error {
+	return DecodeData(m.Datatype, m.Data, v)
+}
+
+var errUnsupportedType = fmt.Errorf("unsupported type")
+
+// textCodec is the codec of DATA_TEXT: strings and encoding.TextMarshaler implementations
+type textCodec struct{}
+
+func (textCodec) Name() string { return "text" }
+
+func (textCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case encoding.TextMarshaler:
+		return v.MarshalText()
+	}
+	return nil, errUnsupportedType
+}
+
+func (textCodec) Unmarshal(data []byte, v any) error {
+	switch p := v.(type) {
+	case *string:
+		*p = string(data)
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+	case *any:
+		*p = string(data)
+	case encoding.TextUnmarshaler:
+		return p.UnmarshalText(data)
+	default:
+		return errUnsupportedType
+	}
+	return nil
+}
+
+// intCodec is the codec of DATA_INT: integers in decimal, also decoded as text into strings, byte slices
+// and encoding.TextUnmarshaler implementations
+type intCodec struct{}
+
+func (intCodec) Name() string { return "int" }
+
+func (intCodec) Marshal(v any) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(nil, rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(nil, rv.Uint(), 10), nil
+	}
+	return nil, errUnsupportedType
+}
+
+func (intCodec) Unmarshal(data []byte, v any) error {
+	switch p := v.(type) {
+	case *any:
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		if err == nil {
+			*p = n
+		}
+		return err
+	case *string:
+		*p = string(data)
+		return nil
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+		return nil
+	case encoding.TextUnmarshaler:
+		return p.UnmarshalText(data)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errUnsupportedType
+	}
+	switch e := rv.Elem(); e.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(data), 10, e.Type().Bits())
+		if err == nil {
+			e.SetInt(n)
+		}
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(string(data), 10, e.Type().Bits())
+		if err == nil {
+			e.SetUint(n)
+		}
+		return err
+	}
+	return errUnsupportedType
+}
+
+// binCodec is the codec of DATA_BIN: byte slices, and encoding.BinaryMarshaler implementations
+type binCodec struct{}
+
+func (binCodec) Name() string { return "binary" }
+
+func (binCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	}
+	return nil, errUnsupportedType
+}
+
+func (binCodec) Unmarshal(data []byte, v any) error {
+	switch p := v.(type) {
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+	case *any:
+		*p = append([]byte(nil), data...)
+	case encoding.BinaryUnmarshaler:
+		return p.UnmarshalBinary(data)
+	default:
+		return errUnsupportedType
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type yamlCodec struct{}
+
+func (yamlCodec) Name() string                       { return "yaml" }
+func (yamlCodec) Marshal(v any) ([]byte, error)      { return yaml.Marshal(v) }
+func (yamlCodec) Unmarshal(data []byte, v any) error { return yaml.Unmarshal(data, v) }
+
+// msgpackCodec is the codec of DATA_MSGPACK. Structs use their msgpack tags, or their json tags without them.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// cborCodec is the codec of DATA_CBOR. Maps are decoded into map[string]any when nothing else is asked for,
+// like with JSON.
+type cborCodec struct {
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	dec, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil))}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{dec: dec}
+}
+
+func (cborCodec) Name() string                         { return "cbor" }
+func (cborCodec) Marshal(v any) ([]byte, error)        { return cbor.Marshal(v) }
+func (c cborCodec) Unmarshal(data []byte, v any) error { return c.dec.Unmarshal(data, v) }
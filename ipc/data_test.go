@@ -0,0 +1,132 @@
+package ipc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+type finding struct {
+	Rule  string   `json:"rule"`
+	Level int      `json:"level"`
+	Tags  []string `json:"tags"`
+}
+
+// TestDataCodecs tests the round trip of a struct through the structured datatypes, and their decoding into an any
+func TestDataCodecs(t *testing.T) {
+	in := finding{Rule: "ssh-bruteforce", Level: 3, Tags: []string{"auth"}}
+	for _, dataType := range []ipc.DataType{ipc.DATA_JSON, ipc.DATA_YAML, ipc.DATA_MSGPACK, ipc.DATA_CBOR} {
+		data, err := ipc.MarshalData(dataType, in)
+		if err != nil {
+			t.Fatalf("Failed to encode as 0x%02x: %v", dataType, err)
+		}
+
+		var out finding
+		if err := ipc.DecodeData(dataType, data, &out); err != nil || out.Rule != in.Rule || out.Level != in.Level || len(out.Tags) != 1 {
+			t.Errorf("Expected %+v from 0x%02x, but got %+v, %v", in, dataType, out, err)
+		}
+
+		var v any
+		msg := ipc.IPCMessage{Datatype: dataType, Data: data}
+		if err := msg.Decode(&v); err != nil {
+			t.Fatalf("Failed to decode 0x%02x into an any: %v", dataType, err)
+		}
+		if m, ok := v.(map[string]any); !ok || m["rule"] != in.Rule {
+			t.Errorf("Expected a map with the rule from 0x%02x, but got %#v", dataType, v)
+		}
+	}
+}
+
+// TestDataCodecsMismatch tests that the codecs refuse values of the wrong type, and data of unknown datatypes
+func TestDataCodecsMismatch(t *testing.T) {
+	if _, err := ipc.MarshalData(ipc.DATA_TEXT, 5); err == nil {
+		t.Errorf("Expected an error for an int as text")
+	}
+	if _, err := ipc.MarshalData(ipc.DATA_INT, "5"); err == nil {
+		t.Errorf("Expected an error for a string as an int")
+	}
+	if _, err := ipc.MarshalData(0x7F, "hello"); err == nil {
+		t.Errorf("Expected an error for an unknown datatype")
+	}
+
+	var s string
+	if err := ipc.DecodeData(ipc.DATA_BIN, []byte("hello"), &s); !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for binary data into a string, but got %v", err)
+	}
+	var b []byte
+	if err := ipc.DecodeData(ipc.DATA_BIN, []byte{1, 2}, &b); err != nil || !bytes.Equal(b, []byte{1, 2}) {
+		t.Errorf("Expected the binary data, but got %v, %v", b, err)
+	}
+	if err := ipc.DecodeData(0x7F, []byte("hello"), &s); !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for an unknown datatype, but got %v", err)
+	}
+}
+
+// TestDecodeIntAsText tests that integers are also decoded as text, like before the codecs
+func TestDecodeIntAsText(t *testing.T) {
+	var s string
+	if err := ipc.DecodeData(ipc.DATA_INT, []byte("42"), &s); err != nil || s != "42" {
+		t.Errorf("Expected 42 as a string, but got %q, %v", s, err)
+	}
+	var b []byte
+	if err := ipc.DecodeData(ipc.DATA_INT, []byte("42"), &b); err != nil || string(b) != "42" {
+		t.Errorf("Expected 42 as bytes, but got %q, %v", b, err)
+	}
+}
+
+// hexCodec is an application datatype, hex-encoded bytes
+type hexCodec struct{}
+
+func (hexCodec) Name() string { return "hex" }
+
+func (hexCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errors.New("not a byte slice")
+	}
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (hexCodec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return errors.New("not a byte slice")
+	}
+	b, err := hex.DecodeString(string(data))
+	*p = b
+	return err
+}
+
+const DATA_HEX = ipc.DATA_CUSTOM + 1
+
+func TestRegisterDataCodec(t *testing.T) {
+	if _, ok := ipc.DataCodecFor(DATA_HEX); !ok {
+		ipc.RegisterDataCodec(DATA_HEX, hexCodec{})
+	}
+
+	data, err := ipc.MarshalData(DATA_HEX, []byte{0xCA, 0xFE})
+	if err != nil || string(data) != "cafe" {
+		t.Fatalf("Expected cafe, but got %q, %v", data, err)
+	}
+	var b []byte
+	if err := ipc.DecodeData(DATA_HEX, data, &b); err != nil || !bytes.Equal(b, []byte{0xCA, 0xFE}) {
+		t.Errorf("Expected the bytes back, but got %x, %v", b, err)
+	}
+	if err := ipc.DecodeData(DATA_HEX, []byte("zz"), &b); !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for invalid hex, but got %v", err)
+	}
+
+	for _, dataType := range []ipc.DataType{ipc.DATA_JSON, DATA_HEX, 0x100} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic registering datatype 0x%02x", int(dataType))
+				}
+			}()
+			ipc.RegisterDataCodec(dataType, hexCodec{})
+		}()
+	}
+}
@@ -0,0 +1,49 @@
+package ipc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MAX_DATAGRAM_SIZE is the size limit of a datagram in datagram mode, envelope included
+const MAX_DATAGRAM_SIZE = 64 * 1024
+
+var (
+	ErrDatagramTooLarge  = fmt.Errorf("%w: datagram too large", ErrInvalidRequest) // The encoded message doesn't fit in a datagram
+	ErrDatagramTruncated = fmt.Errorf("%w: datagram truncated", ErrDecode)         // The datagram was cut short, and can't be decoded
+)
+
+// EncodeDatagram encodes the message in a datagram, on its own: gob type information is repeated in every datagram.
+// A nil codec is GobCodec. Messages larger than MAX_DATAGRAM_SIZE once encoded return ErrDatagramTooLarge.
+func EncodeDatagram(codec Codec, msg *IPCRequest) ([]byte, error) {
+	if codec == nil {
+		codec = GobCodec
+	}
+
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf).Encode(msg); err != nil {
+		return nil, err
+	}
+	if buf.Len() > MAX_DATAGRAM_SIZE {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrDatagramTooLarge, buf.Len(), MAX_DATAGRAM_SIZE)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeDatagram decodes the message of a datagram encoded with EncodeDatagram. A nil codec is GobCodec.
+func DecodeDatagram(codec Codec, b []byte) (IPCRequest, error) {
+	if codec == nil {
+		codec = GobCodec
+	}
+
+	var msg IPCRequest
+	if err := codec.NewDecoder(bytes.NewReader(b)).Decode(&msg); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return msg, fmt.Errorf("%w: %v", ErrDatagramTruncated, err)
+		}
+		return msg, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,42 @@
+package ipc_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestDatagramRoundTrip(t *testing.T) {
+	for _, codec := range []ipc.Codec{nil, ipc.GobCodec, ipc.FrameCodec} {
+		msg := &ipc.IPCRequest{
+			MessageSignature: ipc.IPCID,
+			Header:           ipc.IPCHeader{Identifier: [4]byte{'T', 'E', 'S', 'T'}, MessageType: ipc.MSG_MSG, MessageId: 7},
+			Message:          ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("hello"), StringData: "hello"},
+		}
+		b, err := ipc.EncodeDatagram(codec, msg)
+		if err != nil {
+			t.Fatalf("Failed to encode datagram: %v", err)
+		}
+		got, err := ipc.DecodeDatagram(codec, b)
+		if err != nil {
+			t.Fatalf("Failed to decode datagram: %v", err)
+		}
+		if got.Header != msg.Header || got.Message.StringData != "hello" {
+			t.Errorf("Expected %+v, but got %+v", msg, got)
+		}
+
+		if _, err := ipc.DecodeDatagram(codec, b[:len(b)/2]); !errors.Is(err, ipc.ErrDatagramTruncated) || !errors.Is(err, ipc.ErrDecode) {
+			t.Errorf("Expected ErrDatagramTruncated for half a datagram, but got %v", err)
+		}
+	}
+}
+
+func TestDatagramTooLarge(t *testing.T) {
+	data := strings.Repeat("x", ipc.MAX_DATAGRAM_SIZE)
+	msg := &ipc.IPCRequest{Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte(data)}}
+	if _, err := ipc.EncodeDatagram(nil, msg); !errors.Is(err, ipc.ErrDatagramTooLarge) || !errors.Is(err, ipc.ErrInvalidRequest) {
+		t.Errorf("Expected ErrDatagramTooLarge, but got %v", err)
+	}
+}
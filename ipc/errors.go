@@ -0,0 +1,122 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+)
+
+// ErrorCode identifies the kind of failure reported in a MSG_ERROR message
+type ErrorCode string
+
+const (
+	CODE_CHECKSUM        ErrorCode = "checksum_mismatch" // The checksum of the message doesn't match its data
+	CODE_UNKNOWN_MODULE  ErrorCode = "unknown_module"    // The identifier isn't one of the known modules
+	CODE_UNAUTHORIZED    ErrorCode = "unauthorized"      // The module isn't allowed to send the message
+	CODE_DECODE          ErrorCode = "decode_failed"     // The message or its data could not be decoded
+	CODE_INVALID_REQUEST ErrorCode = "invalid_request"   // The message is well-formed, but not valid
+	CODE_NO_HANDLER      ErrorCode = "no_handler"        // Nothing handles the message
+	CODE_HANDLER         ErrorCode = "handler_failed"    // The handler returned an error
+	CODE_INTERNAL        ErrorCode = "internal"          // The receiver failed, e.g. the handler panicked
+	CODE_TIMEOUT         ErrorCode = "timeout"           // The message was not handled in time
+	CODE_RATE_LIMITED    ErrorCode = "rate_limited"      // The module sent more messages than its rate limit allows
+	CODE_CANCELLED       ErrorCode = "cancelled"         // The sender cancelled the message before it was handled
+)
+
+// Error is the structured payload of a MSG_ERROR message, encoded as DATA_JSON.
+//
+// Errors match with errors.Is on their code, so an error received from the server
+// matches the sentinel of its kind:
+//
+//	_, err := client.SendIPCMessage(req)
+//	if errors.Is(err, ipc.ErrChecksum) {
+//		// Send it again
+//	}
+type Error struct {
+	Code      ErrorCode         `json:"code"`
+	Message   string            `json:"message"`
+	Retryable bool              `json:"retryable"`         // Whether sending the same message again may succeed
+	Details   map[string]string `json:"details,omitempty"` // Additional information, such as the message type
+}
+
+var (
+	ErrChecksum       = &Error{Code: CODE_CHECKSUM, Message: "checksum mismatch", Retryable: true}
+	ErrUnknownModule  = &Error{Code: CODE_UNKNOWN_MODULE, Message: "unknown module"}
+	ErrUnauthorized   = &Error{Code: CODE_UNAUTHORIZED, Message: "unauthorized"}
+	ErrDecode         = &Error{Code: CODE_DECODE, Message: "decode failed"}
+	ErrInvalidRequest = &Error{Code: CODE_INVALID_REQUEST, Message: "invalid request"}
+	ErrNoHandler      = &Error{Code: CODE_NO_HANDLER, Message: "no handler for message"}
+	ErrHandler        = &Error{Code: CODE_HANDLER, Message: "handler failed"}
+	ErrInternal       = &Error{Code: CODE_INTERNAL, Message: "internal error"}
+	ErrTimeout        = &Error{Code: CODE_TIMEOUT, Message: "timeout", Retryable: true}
+	ErrRateLimited    = &Error{Code: CODE_RATE_LIMITED, Message: "rate limited", Retryable: true}
+	ErrCancelled      = &Error{Code: CODE_CANCELLED, Message: "cancelled"}
+)
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether the target is an *Error with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// With returns a copy of the error with the detail added
+func (e *Error) With(key string, value string) *Error {
+	c := *e
+	c.Details = maps.Clone(e.Details)
+	if c.Details == nil {
+		c.Details = map[string]string{}
+	}
+	c.Details[key] = value
+	return &c
+}
+
+// AsError returns the structured form of the error, to send it in a MSG_ERROR message.
+// Errors wrapping an *Error keep its code, with the full text of the error as the message.
+// Deadlines become CODE_TIMEOUT, cancellations CODE_CANCELLED, and any other error CODE_HANDLER.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		if e == err {
+			return e
+		}
+		return &Error{Code: e.Code, Message: err.Error(), Retryable: e.Retryable, Details: e.Details}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: CODE_TIMEOUT, Message: err.Error(), Retryable: true}
+	}
+	if errors.Is(err, context.Canceled) {
+		return &Error{Code: CODE_CANCELLED, Message: err.Error()}
+	}
+	return &Error{Code: CODE_HANDLER, Message: err.Error()}
+}
+
+// ErrorFromMessage returns the error carried by a MSG_ERROR message.
+// Plain text errors from older peers become CODE_HANDLER errors, except the legacy "CHKSUM ERROR".
+func ErrorFromMessage(msg *IPCRequest) *Error {
+	if msg.Message.Datatype == DATA_JSON {
+		var e Error
+		if err := json.Unmarshal(msg.Message.Data, &e); err == nil && e.Code != "" {
+			return &e
+		}
+	}
+
+	text := string(msg.Message.Data)
+	if text == "CHKSUM ERROR" {
+		return &Error{Code: CODE_CHECKSUM, Message: text, Retryable: true}
+	}
+	return &Error{Code: CODE_HANDLER, Message: text}
+}
+
+// NewErrorMessage creates the IPC message for the error
+func NewErrorMessage(err error) IPCMessage {
+	data, jerr := json.Marshal(AsError(err))
+	if jerr != nil {
+		return IPCMessage{Datatype: DATA_TEXT, Data: []byte(err.Error()), StringData: err.Error()}
+	}
+	return IPCMessage{Datatype: DATA_JSON, Data: data, StringData: string(data)}
+}
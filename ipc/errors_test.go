@@ -0,0 +1,56 @@
+package ipc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestErrorMessage(t *testing.T) {
+	err := fmt.Errorf("%w: row 42", ipc.ErrChecksum.With("table", "threat_intel"))
+	msg := &ipc.IPCRequest{Header: ipc.IPCHeader{MessageType: ipc.MSG_ERROR}, Message: ipc.NewErrorMessage(err)}
+	if msg.Message.Datatype != ipc.DATA_JSON {
+		t.Fatalf("Expected the error as JSON, but got datatype %d", msg.Message.Datatype)
+	}
+
+	received := ipc.ErrorFromMessage(msg)
+	if !errors.Is(received, ipc.ErrChecksum) || errors.Is(received, ipc.ErrDecode) {
+		t.Errorf("Expected the error to match ErrChecksum only, but got %#v", received)
+	}
+	if received.Message != "checksum mismatch: row 42" || !received.Retryable || received.Details["table"] != "threat_intel" {
+		t.Errorf("Expected the message, retryable flag and details to be kept, but got %#v", received)
+	}
+	if ipc.ErrChecksum.Details != nil {
+		t.Errorf("Expected With to leave the sentinel untouched, but got %v", ipc.ErrChecksum.Details)
+	}
+}
+
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ipc.ErrorCode
+	}{
+		{ipc.ErrUnknownModule, ipc.CODE_UNKNOWN_MODULE},
+		{ipc.ErrInvalidTopic, ipc.CODE_INVALID_REQUEST},
+		{ipc.ErrInvalidMetadata, ipc.CODE_DECODE},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), ipc.CODE_TIMEOUT},
+		{fmt.Errorf("query: %w", context.Canceled), ipc.CODE_CANCELLED},
+		{errors.New("database is locked"), ipc.CODE_HANDLER},
+	}
+
+	for _, test := range tests {
+		if e := ipc.AsError(test.err); e.Code != test.code || e.Message != test.err.Error() {
+			t.Errorf("Expected code %s with message %q, but got %#v", test.code, test.err, e)
+		}
+	}
+}
+
+func TestLegacyErrorMessage(t *testing.T) {
+	msg := &ipc.IPCRequest{Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("CHKSUM ERROR")}}
+	if err := ipc.ErrorFromMessage(msg); !errors.Is(err, ipc.ErrChecksum) {
+		t.Errorf("Expected the legacy checksum error to match ErrChecksum, but got %#v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/* FRAME FORMAT
+ * The frame codec is a length-prefixed binary format, independent of the Go struct layout.
+ * All integers are big endian.
+ *
+ *	offset  size  field
+ *	0       2     magic           "PZ"
+ *	2       1     version         FRAME_VERSION
+ *	3       1     message type    IPCHeader.MessageType
+ *	4       4     identifier      IPCHeader.Identifier
+ *	8       1     datatype        IPCMessage.Datatype
+ *	9       1     flags           IPCHeader.Flags
+ *	10      8     message id      IPCHeader.MessageId (correlation id)
+ *	18      8     timestamp       IPCRequest.Timestamp
+ *	26      4     checksum32      IPCRequest.Checksum32, the CRC-32 of the payload as computed by the sender
+ *	30      1     signature len   length of IPCRequest.MessageSignature
+ *	31      4     payload len     length of IPCMessage.Data, at most MAX_FRAME_PAYLOAD
+ *	35      n     signature       IPCRequest.MessageSignature
+ *	35+n    m     payload         IPCMessage.Data
+ *	35+n+m  4     frame crc       CRC-32 (IEEE) of all the bytes of the frame before it
+ *
+ * IPCMessage.StringData is not sent; the decoder sets it to the payload as a string.
+ */
+
+const (
+	FRAME_VERSION     = 1        // Version of the frame format
+	FRAME_HEADER_SIZE = 35       // Size of the fixed part of the frame header
+	MAX_FRAME_PAYLOAD = 16 << 20 // Largest payload accepted by the decoder (16 MiB)
+)
+
+// FRAME_MAGIC starts every frame
+var FRAME_MAGIC = [2]byte{'P', 'Z'}
+
+var (
+	ErrFrameMagic    = errors.New("ipc: invalid frame magic")
+	ErrFrameVersion  = errors.New("ipc: unsupported frame version")
+	ErrFrameChecksum = errors.New("ipc: frame checksum mismatch")
+	ErrFrameTooLarge = errors.New("ipc: frame too large")
+)
+
+type frameCodec struct{}
+
+func (frameCodec) Name() string { return "frame" }
+
+func (frameCodec) NewEncoder(w io.Writer) Encoder {
+	return &frameEncoder{w: w}
+}
+
+func (frameCodec) NewDecoder(r io.Reader) Decoder {
+	return &frameDecoder{r: bufio.NewReader(r)}
+}
+
+type frameEncoder struct {
+	w   io.Writer
+	buf []byte // Reused between the frames
+}
+
+// Encode writes the message as one frame
+func (e *frameEncoder) Encode(msg *IPCRequest) error {
+	frame, err := AppendFrame(e.buf[:0], msg)
+	if err != nil {
+		return err
+	}
+	e.buf = frame
+	_, err = e.w.Write(frame)
+	return err
+}
+
+type frameDecoder struct {
+	r      *bufio.Reader
+	header [FRAME_HEADER_SIZE]byte
+}
+
+// Decode reads the next frame into the message
+func (d *frameDecoder) Decode(msg *IPCRequest) error {
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return err
+	}
+	h := d.header[:]
+	if err := checkFrameHeader(h); err != nil {
+		return err
+	}
+
+	sigLen := int(h[30])
+	payloadLen := int(binary.BigEndian.Uint32(h[31:35]))
+	rest := make([]byte, sigLen+payloadLen+4)
+	if _, err := io.ReadFull(d.r, rest); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return decodeFrame(h, rest, msg)
+}
+
+// AppendFrame appends the message encoded as a frame to buf
+func AppendFrame(buf []byte, msg *IPCRequest) ([]byte, error) {
+	if len(msg.MessageSignature) > 255 {
+		return buf, fmt.Errorf("ipc: message signature too long: %d bytes", len(msg.MessageSignature))
+	}
+	if len(msg.Message.Data) > MAX_FRAME_PAYLOAD {
+		return buf, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(msg.Message.Data))
+	}
+	if msg.Message.Datatype < 0 || msg.Message.Datatype > 255 {
+		return buf, fmt.Errorf("ipc: datatype %d does not fit in a frame", msg.Message.Datatype)
+	}
+
+	start := len(buf)
+	buf = append(buf, FRAME_MAGIC[0], FRAME_MAGIC[1], FRAME_VERSION, msg.Header.MessageType)
+	buf = append(buf, msg.Header.Identifier[:]...)
+	buf = append(buf, byte(msg.Message.Datatype), msg.Header.Flags)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(msg.Header.MessageId))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(msg.Timestamp))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(msg.Checksum32))
+	buf = append(buf, byte(len(msg.MessageSignature)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg.Message.Data)))
+	buf = append(buf, msg.MessageSignature...)
+	buf = append(buf, msg.Message.Data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+
+	return buf, nil
+}
+
+// DecodeFrame decodes a complete frame into the message
+func DecodeFrame(frame []byte, msg *IPCRequest) error {
+	if len(frame) < FRAME_HEADER_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	h := frame[:FRAME_HEADER_SIZE]
+	if err := checkFrameHeader(h); err != nil {
+		return err
+	}
+
+	size := FRAME_HEADER_SIZE + int(h[30]) + int(binary.BigEndian.Uint32(h[31:35])) + 4
+	if len(frame) < size {
+		return io.ErrUnexpectedEOF
+	}
+	if len(frame) > size {
+		return fmt.Errorf("ipc: %d trailing bytes after the frame", len(frame)-size)
+	}
+
+	return decodeFrame(h, frame[FRAME_HEADER_SIZE:], msg)
+}
+
+// checkFrameHeader validates the magic, version and payload length of the fixed frame header
+func checkFrameHeader(h []byte) error {
+	if h[0] != FRAME_MAGIC[0] || h[1] != FRAME_MAGIC[1] {
+		return ErrFrameMagic
+	}
+	if h[2] != FRAME_VERSION {
+		return fmt.Errorf("%w: %d", ErrFrameVersion, h[2])
+	}
+	if n := binary.BigEndian.Uint32(h[31:35]); n > MAX_FRAME_PAYLOAD {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, n)
+	}
+	return nil
+}
+
+// decodeFrame decodes the validated fixed header and the rest of the frame (signature, payload and frame crc)
+func decodeFrame(h []byte, rest []byte, msg *IPCRequest) error {
+	body := rest[:len(rest)-4]
+	sum := crc32.Update(crc32.ChecksumIEEE(h), crc32.IEEETable, body)
+	if sum != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return ErrFrameChecksum
+	}
+
+	sigLen := int(h[30])
+	var sig []byte
+	if sigLen > 0 {
+		sig = append([]byte(nil), body[:sigLen]...)
+	}
+	var data []byte
+	if len(body) > sigLen {
+		data = append([]byte(nil), body[sigLen:]...)
+	}
+
+	*msg = IPCRequest{
+		MessageSignature: sig,
+		Header: IPCHeader{
+			Identifier:  [4]byte(h[4:8]),
+			MessageType: h[3],
+			Flags:       h[9],
+			MessageId:   IPCMessageId(binary.BigEndian.Uint64(h[10:18])),
+		},
+		Message: IPCMessage{
+			Datatype:   DataType(h[8]),
+			Data:       data,
+			StringData: string(data),
+		},
+		Timestamp:  int64(binary.BigEndian.Uint64(h[18:26])),
+		Checksum32: int(binary.BigEndian.Uint32(h[26:30])),
+	}
+	return nil
+}
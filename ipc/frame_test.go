@@ -0,0 +1,136 @@
+package ipc_test
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func frameMessage() *ipc.IPCRequest {
+	data := []byte(`{"metadata":{"source":"sigma"}}`)
+	return &ipc.IPCRequest{
+		MessageSignature: []byte("SRVR"),
+		Header: ipc.IPCHeader{
+			Identifier:  [4]byte{'S', 'I', 'G', 'M'},
+			MessageType: ipc.MSG_MSG,
+			Flags:       0x01,
+			MessageId:   0x0102030405060708,
+		},
+		Message: ipc.IPCMessage{
+			Datatype:   ipc.DATA_JSON,
+			Data:       data,
+			StringData: string(data),
+		},
+		Timestamp:  1718000000123456789,
+		Checksum32: int(crc32.ChecksumIEEE(data)),
+	}
+}
+
+// TestFrameRoundTrip tests that a decoded frame equals the encoded message
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []*ipc.IPCRequest{
+		frameMessage(),
+		{Header: ipc.IPCHeader{MessageType: ipc.MSG_PING}},
+	}
+
+	for _, msg := range tests {
+		frame, err := ipc.AppendFrame(nil, msg)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if frame[0] != 'P' || frame[1] != 'Z' || frame[2] != ipc.FRAME_VERSION {
+			t.Errorf("Expected the frame to start with the magic and version, but got % x", frame[:3])
+		}
+
+		var decoded ipc.IPCRequest
+		if err := ipc.DecodeFrame(frame, &decoded); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(*msg, decoded) {
+			t.Errorf("Expected %+v, but got %+v", *msg, decoded)
+		}
+
+		// The same through the stream decoder
+		decoded = ipc.IPCRequest{}
+		if err := ipc.FrameCodec.NewDecoder(bytes.NewReader(frame)).Decode(&decoded); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(*msg, decoded) {
+			t.Errorf("Expected %+v, but got %+v", *msg, decoded)
+		}
+	}
+}
+
+// TestFrameErrors tests that corrupted frames are rejected
+func TestFrameErrors(t *testing.T) {
+	frame, err := ipc.AppendFrame(nil, frameMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(i int, b byte) []byte {
+		c := bytes.Clone(frame)
+		c[i] = b
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		frame    []byte
+		expected error
+	}{
+		{"magic", corrupt(0, 'X'), ipc.ErrFrameMagic},
+		{"version", corrupt(2, ipc.FRAME_VERSION+1), ipc.ErrFrameVersion},
+		{"payload", corrupt(len(frame)-10, 'X'), ipc.ErrFrameChecksum},
+		{"too large", corrupt(31, 0xFF), ipc.ErrFrameTooLarge},
+		{"truncated", frame[:len(frame)-1], io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		var msg ipc.IPCRequest
+		if err := ipc.DecodeFrame(test.frame, &msg); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.expected, err)
+		}
+		msg = ipc.IPCRequest{}
+		if err := ipc.FrameCodec.NewDecoder(bytes.NewReader(test.frame)).Decode(&msg); !errors.Is(err, test.expected) {
+			t.Errorf("%s (stream): expected %v, but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+// FuzzFrameDecode tests that the frame decoder never panics, and that accepted frames encode back to the same bytes
+func FuzzFrameDecode(f *testing.F) {
+	seed, _ := ipc.AppendFrame(nil, frameMessage())
+	f.Add(seed)
+	empty, _ := ipc.AppendFrame(nil, &ipc.IPCRequest{})
+	f.Add(empty)
+	f.Add([]byte("PZ"))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg ipc.IPCRequest
+		if err := ipc.DecodeFrame(data, &msg); err != nil {
+			return
+		}
+		frame, err := ipc.AppendFrame(nil, &msg)
+		if err != nil {
+			t.Fatalf("Failed to encode a decoded frame: %v", err)
+		}
+		if !bytes.Equal(frame, data) {
+			t.Fatalf("Expected the frame to encode back to % x, but got % x", data, frame)
+		}
+
+		// The stream decoder agrees with DecodeFrame
+		var streamed ipc.IPCRequest
+		if err := ipc.FrameCodec.NewDecoder(bytes.NewReader(data)).Decode(&streamed); err != nil {
+			t.Fatalf("Stream decoder failed on a valid frame: %v", err)
+		}
+		if !reflect.DeepEqual(msg, streamed) {
+			t.Fatalf("Expected %+v, but got %+v", msg, streamed)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package ipc
+
+import (
+	"hash/crc32"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pynezz/pynezzentials"
+)
+
+// Health describes the liveness of the peer on a connection
+type Health struct {
+	LastSeen time.Time     // When a message was last received from the peer
+	RTT      time.Duration // Round-trip time of the last ping answered by the peer
+	Missed   int           // Pings in a row the peer didn't answer before the next one was due
+}
+
+// Heartbeat tracks the health of a connection from the messages received and the pings sent on it.
+// It is safe to use from multiple goroutines.
+type Heartbeat struct {
+	mu      sync.Mutex
+	health  Health
+	waiting bool // A ping was sent and not answered yet
+}
+
+// NewHeartbeat returns a Heartbeat for a connection that was just established
+func NewHeartbeat() *Heartbeat {
+	return &Heartbeat{health: Health{LastSeen: time.Now()}}
+}
+
+// Seen records that a message was received from the peer
+func (h *Heartbeat) Seen() {
+	h.mu.Lock()
+	h.health.LastSeen = time.Now()
+	h.mu.Unlock()
+}
+
+// Tick records that a ping is due. A ping that is still unanswered counts as missed.
+// It returns the number of pings missed in a row.
+func (h *Heartbeat) Tick() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.waiting {
+		h.health.Missed++
+	}
+	h.waiting = true
+	return h.health.Missed
+}
+
+// Pong records the answer of the peer to a ping created with NewPing
+func (h *Heartbeat) Pong(pong *IPCRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.waiting = false
+	h.health.Missed = 0
+	if sent, err := strconv.ParseInt(string(pong.Message.Data), 10, 64); err == nil {
+		h.health.RTT = time.Duration(pynezzentials.UnixNanoTimestamp() - sent)
+	}
+}
+
+// Health returns the current health of the connection
+func (h *Heartbeat) Health() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.health
+}
+
+// NewPing creates a MSG_PING message. The time it is sent is the data, so the pong tells the round-trip time.
+func NewPing(identifier [4]byte, id IPCMessageId) *IPCRequest {
+	data := []byte(strconv.FormatInt(pynezzentials.UnixNanoTimestamp(), 10))
+	return &IPCRequest{
+		Header: IPCHeader{
+			Identifier:  identifier,
+			MessageType: MSG_PING,
+			MessageId:   id,
+		},
+		Message: IPCMessage{
+			Datatype:   DATA_INT,
+			Data:       data,
+			StringData: string(data),
+		},
+		Timestamp:  pynezzentials.UnixNanoTimestamp(),
+		Checksum32: int(crc32.ChecksumIEEE(data)),
+	}
+}
+
+// NewCancel creates the MSG_CANCEL notice for the request with the message id
+func NewCancel(identifier [4]byte, id IPCMessageId) *IPCRequest {
+	return &IPCRequest{
+		Header: IPCHeader{
+			Identifier:  identifier,
+			MessageType: MSG_CANCEL,
+			MessageId:   id,
+		},
+		Message:    IPCMessage{Datatype: DATA_TEXT},
+		Timestamp:  pynezzentials.UnixNanoTimestamp(),
+		Checksum32: int(crc32.ChecksumIEEE(nil)),
+	}
+}
+
+// NewPong creates the MSG_PONG answer to the ping, echoing its message id and data
+func NewPong(ping *IPCRequest, identifier [4]byte) *IPCRequest {
+	return &IPCRequest{
+		Header: IPCHeader{
+			Identifier:  identifier,
+			MessageType: MSG_PONG,
+			Flags:       FLAG_REPLY,
+			MessageId:   ping.Header.MessageId,
+		},
+		Message:    ping.Message,
+		Timestamp:  pynezzentials.UnixNanoTimestamp(),
+		Checksum32: ping.Checksum32,
+	}
+}
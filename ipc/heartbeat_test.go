@@ -0,0 +1,32 @@
+package ipc_test
+
+import (
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestHeartbeatMissed(t *testing.T) {
+	hb := ipc.NewHeartbeat()
+
+	if missed := hb.Tick(); missed != 0 {
+		t.Errorf("Expected no missed pongs for the first ping, but got %d", missed)
+	}
+	if missed := hb.Tick(); missed != 1 {
+		t.Errorf("Expected 1 missed pong, but got %d", missed)
+	}
+
+	ping := ipc.NewPing([4]byte{'T', 'E', 'S', 'T'}, 1)
+	pong := ipc.NewPong(ping, [4]byte{'P', 'E', 'E', 'R'})
+	if pong.Header.MessageId != 1 || pong.Header.Flags&ipc.FLAG_REPLY == 0 {
+		t.Errorf("Expected the pong to be a reply to message 1, but got %+v", pong.Header)
+	}
+
+	hb.Pong(pong)
+	if health := hb.Health(); health.Missed != 0 || health.RTT <= 0 {
+		t.Errorf("Expected the pong to reset the missed count and measure the RTT, but got %+v", health)
+	}
+	if missed := hb.Tick(); missed != 0 {
+		t.Errorf("Expected no missed pongs after a pong, but got %d", missed)
+	}
+}
@@ -8,9 +8,8 @@ import (
 	"encoding/gob"
 	"os"
 	"path"
+	"strings"
 	"time"
-
-	"github.com/pynezz/pynezzentials/ansi"
 )
 
 const (
@@ -20,22 +19,26 @@ const (
 	STREAM = "SOCK_STREAM" // Stream socket 		(like TCP)
 	DGRAM  = "SOCK_DGRAM"  // Datagram socket 		(like UDP)
 
-	// Network values if applicable
-	Network = "tcp"
-	Address = "localhost:50052"
+	// Network values of the TCP transport, see ipcserver.WithTCP and ipcclient.WithTCP
+	Network = "tcp"             // Network of the TCP transport
+	Address = "localhost:50052" // Default address of the TCP transport
+
+	// Timeout bounds the steps a peer could otherwise hold up forever: dialing the TCP address, the TLS handshake,
+	// the MSG_CONN handshake (ipc.connect over JSON-RPC) after the server accepts a connection, sending MSG_CANCEL,
+	// and sending a published event to a subscriber, which is disconnected past it
 	Timeout = 1 * time.Second
 )
 
 var IPCID []byte // Identifier of the IPC communication
 
-type IPCMessageId []byte // Identifier of the message
+type IPCMessageId uint64 // Identifier of the message
 
 func SetIPCID(id []byte) {
 	if IPCID == nil {
 		IPCID = id
-		ansi.PrintSuccess("Set IPC ID to " + string(IPCID))
+		Logger().Debug("IPC ID set", "id", string(IPCID))
 	} else {
-		ansi.PrintWarning("IPC ID already set to " + string(IPCID))
+		Logger().Warn("IPC ID already set", "id", string(IPCID))
 	}
 }
 
@@ -43,20 +46,32 @@ func GetIPCStrID() string {
 	return string(IPCID)
 }
 
+// DefaultSock returns the default path of the socket of the named server, <dir>/<name>/<name>.sock.
+// The directory is $XDG_RUNTIME_DIR when it is set, which is private to the user, and the temporary directory otherwise.
 func DefaultSock(name string) string {
-	tmpDir := os.TempDir()                     // Temporary directory (eg. /tmp)
-	subTmpDir := path.Join(tmpDir, name)       // Subdirectory in the temporary directory (eg. /tmp/<subTmpDir>)
-	sock := path.Join(subTmpDir, name+".sock") // Socket file path (eg. /tmp/<subTmpDir>/<name>)
-	sock = path.Clean(sock)                    // Clean the path
+	dir := os.Getenv("XDG_RUNTIME_DIR") // Runtime directory of the user (eg. /run/user/1000)
+	if dir == "" {
+		dir = os.TempDir() // Temporary directory (eg. /tmp)
+	}
+	subDir := path.Join(dir, name)          // Subdirectory for the server (eg. /tmp/<name>)
+	sock := path.Join(subDir, name+".sock") // Socket file path (eg. /tmp/<name>/<name>.sock)
+	sock = path.Clean(sock)                 // Clean the path
 
 	return sock
 }
 
+// IsAbstractSock returns true if the socket is in the Linux abstract namespace, which is written with a leading '@'.
+// Abstract sockets have no file, and so no permissions: any local process can connect to them,
+// so restrict the modules with allowlists (see ipcserver.Allowlist).
+func IsAbstractSock(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
 func init() {
 
 	gob.Register(IPCRequest{})
 	gob.Register(IPCMessage{})
 	gob.Register(IPCHeader{})
-	gob.Register(IPCMessageId{})
+	gob.Register(IPCMessageId(0))
 	gob.Register(IPCResponse{})
 }
@@ -0,0 +1,27 @@
+package ipc_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestDefaultSock(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got := ipc.DefaultSock("srvr"); got != "/run/user/1000/srvr/srvr.sock" {
+		t.Errorf("Expected the socket in XDG_RUNTIME_DIR, but got %s", got)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if got, expected := ipc.DefaultSock("srvr"), filepath.Join(os.TempDir(), "srvr", "srvr.sock"); got != expected {
+		t.Errorf("Expected %s, but got %s", expected, got)
+	}
+}
+
+func TestIsAbstractSock(t *testing.T) {
+	if !ipc.IsAbstractSock("@srvr") || ipc.IsAbstractSock("/tmp/srvr/srvr.sock") {
+		t.Error("Expected only the paths starting with @ to be abstract")
+	}
+}
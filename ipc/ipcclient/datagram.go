@@ -0,0 +1,51 @@
+package ipcclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// ErrNoDatagramSocket is returned by SendDatagram when the client has no datagram socket, see WithDatagramSocket
+var ErrNoDatagramSocket = errors.New("ipcclient: no datagram socket")
+
+// SendDatagram sends the message to the datagram socket of the server, without connecting to it first.
+// The server never answers datagrams: the message is handled like a request, and the response is dropped.
+// Delivery is not guaranteed either, as the server drops the datagrams of unknown or restricted modules.
+//
+// The encoded message must fit in ipc.MAX_DATAGRAM_SIZE bytes, or ipc.ErrDatagramTooLarge is returned.
+func (c *IPCClient) SendDatagram(msg *ipc.IPCRequest) error {
+	if c.dgramSock == "" {
+		return ErrNoDatagramSocket
+	}
+	if msg.Header.MessageId == 0 {
+		msg.Header.MessageId = c.newMessageId()
+	}
+
+	b, err := ipc.EncodeDatagram(c.codec, msg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dgram == nil {
+		if c.dgram, err = net.Dial(ipc.AF_DGRAM, c.dgramSock); err != nil {
+			return err
+		}
+	}
+	if _, err := c.dgram.Write(b); err != nil {
+		c.dgram.Close() // The server may have been restarted, the socket is dialed again on the next datagram
+		c.dgram = nil
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return fmt.Errorf("%w: %v", ipc.ErrDatagramTooLarge, err)
+		}
+		return err
+	}
+
+	c.logMessage("datagram sent", msg)
+	return nil
+}
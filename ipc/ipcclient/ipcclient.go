@@ -1,18 +1,20 @@
 package ipcclient
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"gopkg.in/yaml.v3"
-
 	"github.com/pynezz/pynezzentials"
 	"github.com/pynezz/pynezzentials/ansi"
 	"github.com/pynezz/pynezzentials/fsutil"
@@ -25,49 +27,193 @@ type IPCClient struct {
 
 	Identifier [4]byte // Identifier of the module
 
-	Sock string   // Path to the UNIX domain socket
-	conn net.Conn // Connection to the IPC server (UNIX domain socket)
+	Sock  string    // Path to the UNIX domain socket
+	conn  net.Conn  // Connection to the IPC server (UNIX domain socket)
+	codec ipc.Codec // Wire format of the connection, gob if nil
+
+	addr      string      // TCP address of the server, the client connects to Sock if empty
+	tlsConfig *tls.Config // TLS configuration of the TCP connection, plain TCP if nil
+
+	interactive bool                   // Whether to prompt on the terminal when the server can't be reached, see WithInteractive
+	backoff     *Backoff               // Backoff between the connection attempts, nil disables the retries, see WithReconnect
+	onReconnect func(*IPCClient) error // Called once reconnected, see WithOnReconnect
+	subs        *subscriptions         // Subscriptions of the client, kept across reconnections
+
+	dgramSock string   // Path to the datagram socket of the server, see SendDatagram
+	dgram     net.Conn // Datagram socket, dialed on the first datagram, guarded by mu
+
+	timeout   time.Duration // Timeout of the calls without a deadline, 0 waits forever, see WithTimeout
+	heartbeat time.Duration // Interval of the pings sent to the server, 0 disables them
+	maxMissed int           // Pongs the server may miss in a row before the connection is closed
+
+	log         *slog.Logger // Logger of the client, the default logger of the ipc packages if nil
+	logPayloads bool         // Whether the payloads of the messages are logged
+	redact      ipc.Redactor // What to log of the payloads
+
+	mu     sync.Mutex
+	sess   *session      // The current connection to the server, nil until connected
+	closed bool          // Set by Close, until connecting again
+	stop   chan struct{} // Closed by Close, to stop the reconnections
+	nextId atomic.Uint64 // Last message id used by the client
 }
 
 // NewIPCClient creates a new IPC client and returns it.
 // The name is the name of the module, and the socketPath is the path to the UNIX domain socket.
-func NewIPCClient(name string, identifier string, serverId string) *IPCClient {
+func NewIPCClient(name string, identifier string, serverId string, opts ...Option) *IPCClient {
 	upper := strings.ToUpper(serverId)
 	ipc.SetIPCID([]byte(upper)) // What server to communicate with. Used for requests and responses
 	var identifierBytes [4]byte
 	copy(identifierBytes[:], identifier)
 	c := &IPCClient{
 		Name:       name,
-		Identifier: identifierBytes, // Set the identifier of the client
+		Identifier: identifierBytes,           // Set the identifier of the client
+		Sock:       ipc.DefaultSock(serverId), // Lowercase serverId
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.addr == "" {
+		c.SetSocket(c.Sock)
 	}
-	c.SetSocket(ipc.DefaultSock(serverId)) // Lowercase serverId
 	return c
 }
 
-// Connect to the IPC server (UNIX domain socket)
-// Parameter is the name of the module
-// And identifier is the identifier of the server
-func (c *IPCClient) Connect() error {
-	c.SetDescf("IPC client for %s", c.Name)
-
-	fmt.Println("IPC client connecting to", c.Sock)
+// connect makes one attempt to connect, and replaces the session with the new one.
+// When reconnecting, prev is the session that ended: the new session is only used if it is still the current one,
+// and its subscriptions are restored before it replaces prev, and before the OnReconnect hook.
+func (c *IPCClient) connect(ctx context.Context, prev *session) error {
+	var log *slog.Logger
+	if c.addr != "" {
+		log = c.logger().With("addr", c.addr, "tls", c.tlsConfig != nil)
+	} else {
+		log = c.logger().With("socket", c.Sock)
+	}
+	log.Debug("connecting")
 
-	conn, err := net.Dial("unix", c.Sock)
+	conn, err := c.dial()
 	if err != nil {
-		fmt.Println("Dial error:", err)
+		log.Debug("failed to connect", "err", err)
 		return err
 	}
-	c.conn = conn
+	sess := newSession(ipc.NewStream(conn, c.codec), c.Identifier, c.subs, log)
 	// c.Identifier = ipc.IDENTIFIERS[identifier]
 
-	ansi.PrintColorAndBg(ansi.BgGray, ansi.BgCyan, "Connected to "+c.Sock)
+	if err := c.handshake(ctx, sess); err != nil {
+		conn.Close()
+		return err
+	}
+	if prev != nil {
+		if err := c.restore(sess); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	if c.closed || (prev != nil && c.sess != prev) {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrClientClosed
+	}
+	prevConn := c.conn
+	c.conn = conn
+	c.sess = sess
+	c.mu.Unlock()
+	if prev == nil && prevConn != nil {
+		prevConn.Close() // Replaced by a concurrent Connect, its watch returns as the session isn't current anymore
+	}
+
+	if c.heartbeat > 0 {
+		go sess.keepalive(c.heartbeat, c.maxMissed, c.newMessageId)
+	}
+	if prev != nil && c.onReconnect != nil {
+		if err := c.onReconnect(c); err != nil {
+			// The next attempt starts over, from the session that was lost
+			c.mu.Lock()
+			if c.sess == sess {
+				c.conn, c.sess = prevConn, prev
+			}
+			c.mu.Unlock()
+			conn.Close()
+			return fmt.Errorf("reconnect hook: %w", err)
+		}
+	}
+	go c.watch(sess)
+
+	if prev != nil {
+		log.Info("reconnected", "name", c.Name, "identifier", string(c.Identifier[:]))
+	} else {
+		log.Info("connected", "name", c.Name, "identifier", string(c.Identifier[:]))
+	}
+
+	return nil
+}
+
+// dial connects to the socket, or to the TCP address within ipc.Timeout
+func (c *IPCClient) dial() (net.Conn, error) {
+	if c.addr == "" {
+		return net.Dial("unix", c.Sock)
+	}
 
-	// Print box with client info
-	ansi.PrintColor(ansi.Cyan, c.Stringify())
+	dialer := &net.Dialer{Timeout: ipc.Timeout}
+	if c.tlsConfig == nil {
+		return dialer.Dial(ipc.Network, c.addr)
+	}
+	conn, err := tls.DialWithDialer(dialer, ipc.Network, c.addr, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
 
+// handshake announces the identifier of the client and the protocol version to the server.
+// The server answers with MSG_CONNACK if it knows the module, or refuses the connection.
+func (c *IPCClient) handshake(ctx context.Context, sess *session) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	req, err := c.EncodeReq(ipc.PROTOCOL_VERSION, ipc.MSG_CONN, ipc.DATA_INT)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	res, err := sess.roundTrip(ctx, req)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	if res.Header.MessageType == ipc.MSG_ERROR {
+		return fmt.Errorf("handshake refused: %w", ipc.ErrorFromMessage(&res))
+	}
+	if res.Header.MessageType != ipc.MSG_CONNACK {
+		return fmt.Errorf("handshake refused: unexpected message type 0x%02x", res.Header.MessageType)
+	}
 	return nil
 }
 
+// session returns the current connection to the server
+func (c *IPCClient) session() (*session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sess == nil {
+		return nil, ErrNotConnected
+	}
+	return c.sess, nil
+}
+
+// Health returns the health of the connection to the server.
+// The round-trip time is only measured with heartbeats enabled, see WithHeartbeat.
+func (c *IPCClient) Health() (ipc.Health, error) {
+	sess, err := c.session()
+	if err != nil {
+		return ipc.Health{}, err
+	}
+	return sess.hb.Health(), nil
+}
+
+// newMessageId returns a message id that is unique for the client
+func (c *IPCClient) newMessageId() ipc.IPCMessageId {
+	return ipc.IPCMessageId(c.nextId.Add(1))
+}
+
 // Set description with format string for easier type conversion
 func (c *IPCClient) SetDescf(desc string, args ...interface{}) {
 	c.Desc = fmt.Sprintf(desc, args...)
@@ -75,15 +221,15 @@ func (c *IPCClient) SetDescf(desc string, args ...interface{}) {
 
 func (c *IPCClient) Stringify() string {
 	if c.Name == "" {
-		ansi.PrintWarning("No name set for IPCClient")
+		c.logger().Warn("No name set for IPCClient")
 		c.Name = "IPCClient"
 	}
 	if c.Desc == "" {
-		ansi.PrintWarning("No description set for IPCClient")
+		c.logger().Warn("No description set for IPCClient")
 		c.Desc = "IPC testing client"
 	}
 	if c.Identifier == [4]byte{} {
-		ansi.PrintWarning("No identifier set for IPCClient")
+		c.logger().Warn("No identifier set for IPCClient")
 		c.Identifier = ipc.IDENTIFIERS["test_client"]
 	}
 
@@ -114,11 +260,17 @@ func existHandler(exist bool) (bool, error) {
 	return false, nil
 }
 
+// SetSocket sets the path of the UNIX domain socket.
+// With WithInteractive, it asks on the terminal whether to retry until the socket exists.
+// Otherwise it returns right away, and Connect reports a missing socket.
 func (c *IPCClient) SetSocket(socketPath string) error {
 	if socketPath == "" {
 		socketPath = ipc.DefaultSock(ipc.GetIPCStrID())
 	}
 	c.Sock = socketPath
+	if !c.interactive {
+		return nil
+	}
 
 	retry, err := existHandler(socketExists(socketPath))
 	if err != nil {
@@ -143,20 +295,30 @@ func userRetry() bool {
 	return retry[0] != 'n' // If the user doesn't want to retry, return false
 }
 
+// AwaitResponse waits for the next message from the server that is not a reply to a request.
+// It is the same as calling AwaitResponseContext with context.Background().
 func (c *IPCClient) AwaitResponse() (ipc.IPCMessage, error) {
+	return c.AwaitResponseContext(context.Background())
+}
+
+// AwaitResponseContext waits for the next message from the server that is not a reply to a request,
+// until the context is done, or the timeout set with WithTimeout expires.
+func (c *IPCClient) AwaitResponseContext(ctx context.Context) (ipc.IPCMessage, error) {
 	var err error
 	var response ipc.IPCMessage
 
-	if c.conn == nil {
-		ansi.PrintError("Connection not established")
+	sess, err := c.session()
+	if err != nil {
+		return response, err
 	}
 
-	req, err := parseConnection(c.conn)
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	req, err := sess.next(ctx)
 	if err != nil {
-		if err.Error() == "EOF" {
+		if errors.Is(err, io.EOF) {
 			return response, fmt.Errorf("client disconnected")
 		}
-		ansi.PrintError("Error parsing the connection")
 		return response, err
 	}
 
@@ -165,20 +327,11 @@ func (c *IPCClient) AwaitResponse() (ipc.IPCMessage, error) {
 		Data:       req.Message.Data,
 		StringData: req.Message.StringData,
 	}
+	c.logReceived(&req)
 
-	if len(req.Message.StringData) > 100 {
-		ansi.PrintSuccess("Received message from server (truncated): " + response.StringData[:100] + "...")
-	} else {
-		ansi.PrintSuccess("Received message from server: " + response.StringData)
-	}
-
-	if uint32(req.Checksum32) == crc32.ChecksumIEEE(req.Message.Data) {
-		ansi.PrintColorf(ansi.LightCyan, "Message type: %v\n", req.Header.MessageType)
-		ansi.PrintSuccess("Checksums match")
-	} else {
-		ansi.PrintError("Checksums do not match")
+	if req.Header.MessageType == ipc.MSG_ERROR {
+		return response, ipc.ErrorFromMessage(&req)
 	}
-
 	return response, nil
 }
 
@@ -189,19 +342,20 @@ func (c *IPCClient) ClientListen() ipc.IPCResponse {
 
 	response := ipc.IPCResponse{}
 
-	if c.conn == nil {
-		ansi.PrintError("Connection not established")
+	sess, err := c.session()
+	if err != nil {
+		c.logger().Error("connection not established")
 		return response
 	}
 
-	res, err := parseConnection(c.conn)
+	res, err := sess.next(context.Background())
 	if err != nil {
 		response.Success = false
-		if err.Error() == "EOF" {
-			ansi.PrintItalic("[<- ->] client disconnected")
+		if errors.Is(err, io.EOF) {
+			c.logger().Debug("client disconnected")
 			return response
 		}
-		ansi.PrintError("Error parsing the connection")
+		c.logger().Error("failed to receive a message", "err", err)
 		return response
 	}
 
@@ -212,139 +366,187 @@ func (c *IPCClient) ClientListen() ipc.IPCResponse {
 		Checksum32: res.Checksum32,
 	}
 
-	if len(response.Message) > 100 {
-		ansi.PrintSuccess("Received message from server (truncated): " + response.Message[:100] + "...")
-	} else {
-		ansi.PrintSuccess("Received message from server: " + response.Message)
-	}
-
-	if response.Success {
-		ansi.PrintColorf(ansi.LightCyan, "Message type: %v\n", res.Header.MessageType)
-		ansi.PrintSuccess("Checksums match")
-	} else {
-		ansi.PrintError("Checksums do not match")
-	}
+	c.logReceived(&res)
 
 	return response
 }
 
-// SendIPCMessage sends an IPC message to the server.
-// To get the response, you can pass a function that will be called after the message is sent.
+// SendIPCMessage sends an IPC message to the server, and waits for the reply with the same message id.
+// It is safe to call from multiple goroutines, the replies are routed to the right caller.
+// It waits for the timeout set with WithTimeout, or forever without one. Use Call to pass a context.
+//
+// A MSG_ERROR reply is returned as an *ipc.Error, which matches the sentinels of its kind with errors.Is,
+// such as ipc.ErrChecksum or ipc.ErrNoHandler.
+//
+// To handle the response yourself, you can pass a function that will be called after the message is sent,
+// instead of waiting for the reply.
 //
 // Example:
 //
-//	err := client.SendIPCMessage(req, func() (ipc.IPCMessage, error) {
-//		return client.ParseResponse()
+//	res, err := client.SendIPCMessage(req, func() (ipc.IPCMessage, error) {
+//		return client.AwaitResponse()
 //	})
 func (c *IPCClient) SendIPCMessage(msg *ipc.IPCRequest, then ...func() (ipc.IPCMessage, error)) (ipc.IPCMessage, error) {
-	var bBuffer bytes.Buffer
 	var response ipc.IPCMessage
 
-	encoder := gob.NewEncoder(&bBuffer)
-	err := encoder.Encode(msg)
+	sess, err := c.session()
 	if err != nil {
-		return response, err
-	}
-
-	if c.conn == nil {
-		if !userRetry() {
-			return response, fmt.Errorf("connection not established")
-		} else {
-			c.Connect() // Get the name of the IPC identifier from the socket path
+		if !c.interactive || !userRetry() {
+			return response, err
+		}
+		if err := c.Connect(); err != nil { // Get the name of the IPC identifier from the socket path
+			return response, err
+		}
+		if sess, err = c.session(); err != nil {
+			return response, err
 		}
 	}
 
-	ansi.PrintItalic("Sending encoded message to server...")
-	_, err = c.conn.Write(bBuffer.Bytes())
-	if err != nil {
-		fmt.Println("Write error:", err)
-		return response, err
-	}
-	if len(msg.Message.StringData) > 200 {
-		ansi.PrintSuccess("Message sent (truncated): " + msg.Message.StringData[:200] + "...")
-	} else {
-		ansi.PrintSuccess("Message sent: " + msg.Message.StringData)
+	if msg.Header.MessageId == 0 {
+		msg.Header.MessageId = c.newMessageId()
 	}
 
-	//  next is a function that will be called after the message is sent
-	next := func() (ipc.IPCMessage, error) {
-		ansi.PrintDebug("Awaiting response...")
-		return c.AwaitResponse()
-	}
+	c.logMessage("sending message", msg)
 
 	if len(then) > 0 {
+		if err := sess.stream.Send(msg); err != nil {
+			c.logger().Error("failed to send message", "err", err)
+			return response, err
+		}
 		response, err = then[0]()
 	} else {
-		response, err = next()
+		response, err = c.call(context.Background(), sess, msg)
+		var ipcErr *ipc.Error
+		if errors.As(err, &ipcErr) && !errors.Is(err, context.DeadlineExceeded) {
+			return response, err // Answered by the server
+		}
 	}
 
 	if err != nil {
-		ansi.PrintError("Error receiving response from server")
-		fmt.Println(err)
+		c.logger().Error("failed to receive the response", "err", err)
 	}
 
-	return response, nil
+	return response, err
+}
+
+// Call sends the request to the server, and waits for the reply with the same message id until the context is done.
+// The timeout set with WithTimeout applies when the context has no deadline.
+//
+// If the context is done first, its error is returned, context.Canceled, or context.DeadlineExceeded wrapped with
+// ipc.ErrTimeout so it matches both, and the server is sent a MSG_CANCEL notice: the context of the handler is cancelled, so it can abandon the work.
+// A MSG_ERROR reply is returned as an *ipc.Error, like with SendIPCMessage.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+//	defer cancel()
+//	res, err := client.Call(ctx, client.CreateReq("scan", ipc.MSG_MSG, ipc.DATA_TEXT))
+//	if errors.Is(err, context.DeadlineExceeded) {
+//		// The server is busy, or stuck
+//	}
+func (c *IPCClient) Call(ctx context.Context, msg *ipc.IPCRequest) (ipc.IPCMessage, error) {
+	sess, err := c.session()
+	if err != nil {
+		return ipc.IPCMessage{}, err
+	}
+	if msg.Header.MessageId == 0 {
+		msg.Header.MessageId = c.newMessageId()
+	}
+
+	c.logMessage("sending message", msg)
+	return c.call(ctx, sess, msg)
+}
+
+// call sends the request on the session, and waits for the reply until the context is done
+func (c *IPCClient) call(ctx context.Context, sess *session, msg *ipc.IPCRequest) (ipc.IPCMessage, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	res, err := sess.roundTrip(ctx, msg)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.logger().Debug("request abandoned", ipc.MessageAttr(msg), "err", err)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %w", ipc.ErrTimeout, err) // Retryable, like the timeouts of the server
+		}
+		return ipc.IPCMessage{}, err
+	}
+	c.logReceived(&res)
+	if res.Header.MessageType == ipc.MSG_ERROR {
+		return res.Message, ipc.ErrorFromMessage(&res)
+	}
+	return res.Message, nil
+}
+
+// callContext applies the timeout of the client to the context, unless it has a deadline already
+func (c *IPCClient) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// logReceived logs a message received from the server, and warns if its checksum doesn't match
+func (c *IPCClient) logReceived(req *ipc.IPCRequest) {
+	c.logMessage("message received", req)
+	if uint32(req.Checksum32) != crc32.ChecksumIEEE(req.Message.Data) {
+		c.logger().Warn("checksum of the message does not match", ipc.MessageAttr(req))
+	}
 }
 
 // NewMessage creates a new IPC message.
 func (c *IPCClient) CreateReq(message string, t ipc.MsgType, dataType ipc.DataType) *ipc.IPCRequest {
-	checksum := crc32.ChecksumIEEE([]byte(message))
-	ansi.PrintDebug("Created IPC checksum: " + strconv.Itoa(int(checksum)))
+	return c.request(t, dataType, []byte(message))
+}
 
+// request creates a new IPC message with the data as is
+func (c *IPCClient) request(t ipc.MsgType, dataType ipc.DataType, data []byte) *ipc.IPCRequest {
 	return &ipc.IPCRequest{
 		MessageSignature: ipc.IPCID,
 		Header: ipc.IPCHeader{
 			Identifier:  c.Identifier,
 			MessageType: byte(t),
+			MessageId:   c.newMessageId(),
 		},
 		Message: ipc.IPCMessage{
 			Datatype:   dataType,
-			Data:       []byte(message),
-			StringData: message,
+			Data:       data,
+			StringData: string(data),
 		},
 		Timestamp:  pynezzentials.UnixNanoTimestamp(),
-		Checksum32: int(checksum),
+		Checksum32: int(crc32.ChecksumIEEE(data)),
 	}
 }
 
+// CreateGenericReq is like EncodeReq, but logs the error and returns nil if the message can't be encoded.
+// Prefer EncodeReq, as the nil request would only fail once it is sent.
 func (c *IPCClient) CreateGenericReq(message interface{}, t ipc.MsgType, dataType ipc.DataType) *ipc.IPCRequest {
-	ansi.PrintDebug("[CLIENT] Creating a generic IPC request...")
-	var data []byte
-	var err error
+	req, err := c.EncodeReq(message, t, dataType)
+	if err != nil {
+		c.logger().Error("failed to encode message", "err", err)
+		return nil
+	}
+	return req
+}
 
-	switch dataType {
-	case ipc.DATA_TEXT:
-		data = []byte(message.(string))
-	case ipc.DATA_INT:
-		data = []byte(strconv.Itoa(message.(int)))
-	case ipc.DATA_JSON:
-		data, err = json.Marshal(message)
-		if err != nil {
-			// Handle the error
-			ansi.PrintError("[CLIENT] Error marshaling JSON data:" + err.Error())
-			return nil
-		}
-		ansi.PrintDebug("[CLIENT] Marshaling JSON data...")
-
-	case ipc.DATA_YAML:
-		fmt.Println("[CLIENT] Marshaling YAML data...")
-		data, err = yaml.Marshal(message)
-		if err != nil {
-			ansi.PrintError("[CLIENT] Error marshaling YAML data:" + err.Error())
-			return nil
-		}
-	case ipc.DATA_BIN:
-		data = message.([]byte)
+// EncodeReq creates a request with the message encoded as the datatype, with its registered codec
+// (see ipc.RegisterDataCodec). It returns an error if the codec can't encode the message: DATA_TEXT takes
+// a string, DATA_INT an integer and DATA_BIN a byte slice. See Call for typed requests.
+func (c *IPCClient) EncodeReq(message interface{}, t ipc.MsgType, dataType ipc.DataType) (*ipc.IPCRequest, error) {
+	data, err := ipc.MarshalData(dataType, message)
+	if err != nil {
+		return nil, err
 	}
 
 	checksum := crc32.ChecksumIEEE(data)
-	ansi.PrintDebug("[CLIENT] Created IPC checksum: " + strconv.Itoa(int(checksum)))
 
 	return &ipc.IPCRequest{
 		MessageSignature: ipc.IPCID,
 		Header: ipc.IPCHeader{
 			Identifier:  c.Identifier,
 			MessageType: byte(t),
+			MessageId:   c.newMessageId(),
 		},
 		Message: ipc.IPCMessage{
 			Datatype:   dataType,
@@ -353,35 +555,31 @@ func (c *IPCClient) CreateGenericReq(message interface{}, t ipc.MsgType, dataTyp
 		},
 		Timestamp:  pynezzentials.UnixNanoTimestamp(),
 		Checksum32: int(checksum),
-	}
+	}, nil
 }
 
 // Return the parsed IPCRequest object
-func parseConnection(c net.Conn) (ipc.IPCRequest, error) {
-	var request ipc.IPCRequest
-	// var reqBuffer bytes.Buffer
-
-	ansi.PrintColorf(ansi.LightCyan, "[CLIENT] Decoding the bytes to a request struct... %v", c)
-
-	decoder := gob.NewDecoder(c)
-	err := decoder.Decode(&request)
-	if err != nil {
-		if err.Error() == "EOF" {
-			ansi.PrintWarning("parseConnection: EOF error, connection closed")
-			return request, err
-		}
-		ansi.PrintWarning("parseConnection: Error decoding the request \n > " + err.Error())
-		return request, err
-	}
-
-	fmt.Printf("Message ID: %v\n", request.MessageSignature)
-
-	return request, nil
+func parseConnection(stream *ipc.Stream) (ipc.IPCRequest, error) {
+	return stream.Receive()
 }
 
-// Close the connection
+// Close the connection, and stop reconnecting
 func (c *IPCClient) Close() {
-	c.conn.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		if c.stop != nil {
+			close(c.stop)
+		}
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	if c.dgram != nil {
+		c.dgram.Close()
+		c.dgram = nil
+	}
 }
 
 func countDown(secLeft int) { // i--
@@ -393,6 +591,9 @@ func countDown(secLeft int) { // i--
 }
 
 func socketExists(socketPath string) bool {
+	if ipc.IsAbstractSock(socketPath) {
+		return true // There is no file to check
+	}
 	if !fsutil.FileExists(socketPath) {
 		ansi.PrintError("The UNIX domain socket does not exist")
 		ansi.PrintInfo("Retrying in 5 seconds...")
@@ -0,0 +1,29 @@
+package ipcclient
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// logger returns the logger of the client
+func (c *IPCClient) logger() *slog.Logger {
+	if c.log != nil {
+		return c.log
+	}
+	return ipc.Logger()
+}
+
+// logMessage logs a message at debug level, with its payload if payload logging is enabled
+func (c *IPCClient) logMessage(msg string, req *ipc.IPCRequest) {
+	log := c.logger()
+	if !log.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	if c.logPayloads {
+		log.Debug(msg, ipc.MessageAttr(req), ipc.PayloadAttr(req, c.redact))
+	} else {
+		log.Debug(msg, ipc.MessageAttr(req))
+	}
+}
@@ -0,0 +1,126 @@
+package ipcclient
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// DefaultHeartbeatMissed is the default number of pongs the server may miss in a row before the connection is closed
+const DefaultHeartbeatMissed = 3
+
+// Option configures an IPCClient
+//
+// Example:
+//
+//	client := ipcclient.NewIPCClient("sigma", "SIGM", "srvr", ipcclient.WithCodec(ipc.FrameCodec))
+type Option func(*IPCClient)
+
+// WithSocketPath sets the path of the UNIX domain socket, instead of the default path derived from the server id
+func WithSocketPath(path string) Option {
+	return func(c *IPCClient) {
+		c.Sock = path
+	}
+}
+
+// WithTCP connects to the server at the TCP address instead of a UNIX domain socket, see ipcserver.WithTCP.
+// An empty address is ipc.Address. Connecting times out after ipc.Timeout.
+func WithTCP(addr string) Option {
+	return func(c *IPCClient) {
+		if addr == "" {
+			addr = ipc.Address
+		}
+		c.addr = addr
+	}
+}
+
+// WithTLS connects over TLS with the configuration, see ipc.ClientTLSConfig.
+// Set a certificate in the configuration if the server requires mutual TLS. It has no effect without WithTCP.
+func WithTLS(config *tls.Config) Option {
+	return func(c *IPCClient) {
+		c.tlsConfig = config
+	}
+}
+
+// WithCodec sets the wire format of the connection. It must match the codec of the server.
+// The default is ipc.GobCodec.
+func WithCodec(codec ipc.Codec) Option {
+	return func(c *IPCClient) {
+		c.codec = codec
+	}
+}
+
+// WithTimeout sets how long the calls wait for the reply of the server, when their context has no deadline.
+// It applies to SendIPCMessage, Call, AwaitResponseContext and the handshake. The calls wait forever by default.
+func WithTimeout(d time.Duration) Option {
+	return func(c *IPCClient) {
+		c.timeout = d
+	}
+}
+
+// WithHeartbeat pings the server at the given interval, and closes the connection when the server misses
+// maxMissed pongs in a row. A maxMissed of 0 or less uses DefaultHeartbeatMissed.
+// Heartbeats are disabled by default. The health of the connection is available from Health.
+func WithHeartbeat(interval time.Duration, maxMissed int) Option {
+	return func(c *IPCClient) {
+		c.heartbeat = interval
+		if maxMissed > 0 {
+			c.maxMissed = maxMissed
+		} else {
+			c.maxMissed = DefaultHeartbeatMissed
+		}
+	}
+}
+
+// WithLogger sets the logger of the client, instead of the default logger of the ipc packages (see ipc.SetLogger)
+func WithLogger(l *slog.Logger) Option {
+	return func(c *IPCClient) {
+		c.log = l
+	}
+}
+
+// WithPayloadLogging logs the payloads of the messages at debug level, as returned by the redactor.
+// A nil redactor logs the payloads as is. Payloads are not logged by default.
+func WithPayloadLogging(redact ipc.Redactor) Option {
+	return func(c *IPCClient) {
+		c.logPayloads = true
+		c.redact = redact
+	}
+}
+
+// WithDatagramSocket sets the path of the datagram socket of the server, to send messages with SendDatagram.
+// The server must listen on it, see ipcserver.WithDatagramSocket.
+func WithDatagramSocket(path string) Option {
+	return func(c *IPCClient) {
+		c.dgramSock = path
+	}
+}
+
+// WithInteractive asks on the terminal whether to retry when the socket doesn't exist, or when a message is sent
+// before Connect, as the client did before it could reconnect by itself. It is meant for command line tools,
+// a daemon would wait forever for an answer.
+func WithInteractive() Option {
+	return func(c *IPCClient) {
+		c.interactive = true
+	}
+}
+
+// WithReconnect retries to connect with the backoff, in Connect and when the connection drops.
+// On reconnection the handshake is sent again, the subscriptions are restored, and the hook set with WithOnReconnect is called.
+// Requests sent while the client reconnects fail with the error that ended the connection.
+// Reconnection is disabled by default: the subscriptions are closed when the connection drops.
+func WithReconnect(b Backoff) Option {
+	return func(c *IPCClient) {
+		c.backoff = &b
+	}
+}
+
+// WithOnReconnect calls the hook once the client reconnected and restored the subscriptions, to restore the state
+// the server keeps for the module. If the hook returns an error, the connection is closed and attempted again.
+func WithOnReconnect(hook func(c *IPCClient) error) Option {
+	return func(c *IPCClient) {
+		c.onReconnect = hook
+	}
+}
@@ -0,0 +1,164 @@
+package ipcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// ErrClientClosed is returned when the client is closed while it connects
+var ErrClientClosed = errors.New("ipcclient: client closed")
+
+// ErrAlreadyConnected is returned by Connect when the client is connected already
+var ErrAlreadyConnected = errors.New("ipcclient: already connected")
+
+// Backoff is how long the client waits between connection attempts, see WithReconnect.
+// The delay grows exponentially from Initial to Max, and is spread by a random jitter.
+type Backoff struct {
+	Initial    time.Duration // Delay before the second attempt
+	Max        time.Duration // Longest delay, unbounded if 0
+	Multiplier float64       // Growth of the delay after every attempt, 2 if less than 1
+	Jitter     float64       // Fraction of the delay added or removed at random, between 0 and 1
+	MaxRetries int           // Attempts after the first before giving up, unlimited if 0
+}
+
+// DefaultBackoff retries forever, from 100ms up to 30s between attempts
+var DefaultBackoff = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns how long to wait after the failed attempt, counted from 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(b.Initial) * math.Pow(multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if jitter := min(max(b.Jitter, 0), 1); jitter > 0 {
+		d += d * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retry returns true if another attempt may follow the failed attempt, counted from 0
+func (b Backoff) retry(attempt int) bool {
+	return b.MaxRetries <= 0 || attempt < b.MaxRetries
+}
+
+// Connect connects to the IPC server (UNIX domain socket, or TCP with WithTCP), and completes the handshake.
+// It is the same as calling ConnectContext with context.Background().
+func (c *IPCClient) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext connects to the IPC server, and completes the handshake.
+//
+// With WithReconnect, failed attempts are retried with the backoff until the context is done or the retries run out,
+// and the client reconnects by itself when the connection drops. Otherwise it makes a single attempt.
+// It returns ErrAlreadyConnected if the client is connected, until it is closed or its connection drops.
+func (c *IPCClient) ConnectContext(ctx context.Context) error {
+	c.SetDescf("IPC client for %s", c.Name)
+
+	c.mu.Lock()
+	if !c.closed && c.sess != nil && !c.sess.ended() {
+		c.mu.Unlock()
+		return ErrAlreadyConnected
+	}
+	if c.stop == nil || c.closed {
+		c.stop = make(chan struct{})
+		c.closed = false
+	}
+	if c.subs == nil {
+		c.subs = newSubscriptions()
+	}
+	stop := c.stop
+	c.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		err := c.connect(ctx, nil)
+		if err == nil || errors.Is(err, ErrClientClosed) {
+			return err
+		}
+		if c.backoff == nil || !c.backoff.retry(attempt) {
+			c.logger().Error("failed to connect", "err", err)
+			return err
+		}
+
+		delay := c.backoff.Delay(attempt)
+		c.logger().Debug("retrying to connect", "attempt", attempt+1, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-stop:
+			return ErrClientClosed
+		}
+	}
+}
+
+// watch waits for the session to end, and reconnects if the client may, or closes the subscriptions
+func (c *IPCClient) watch(sess *session) {
+	<-sess.done
+
+	c.mu.Lock()
+	current, closed, stop := c.sess == sess, c.closed, c.stop
+	c.mu.Unlock()
+	if !current {
+		return // Connected again already
+	}
+	if closed || c.backoff == nil {
+		c.subs.closeAll()
+		return
+	}
+	c.reconnect(sess, stop)
+}
+
+// reconnect replaces the session that ended with a new one, waiting with the backoff between the attempts
+func (c *IPCClient) reconnect(prev *session, stop <-chan struct{}) {
+	log := c.logger()
+	log.Warn("connection lost, reconnecting", "err", prev.err)
+
+	for attempt := 0; c.backoff.retry(attempt); attempt++ {
+		delay := c.backoff.Delay(attempt)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			c.subs.closeAll()
+			return
+		}
+
+		err := c.connect(context.Background(), prev)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrClientClosed) {
+			c.subs.closeAll()
+			return
+		}
+		log.Warn("failed to reconnect", "attempt", attempt+1, "err", err)
+	}
+
+	log.Error("giving up reconnecting", "retries", c.backoff.MaxRetries)
+	c.subs.closeAll()
+}
+
+// restore subscribes the new session to the topics of the subscriptions
+func (c *IPCClient) restore(sess *session) error {
+	for _, topic := range c.subs.topics() {
+		if err := c.control(sess, c.request(ipc.MSG_SUBSCRIBE, ipc.DATA_TEXT, []byte(topic))); err != nil {
+			return fmt.Errorf("subscribe to %s: %w", topic, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package ipcclient_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+)
+
+// TestBackoff tests the delays between the attempts to connect, with and without jitter
+func TestBackoff(t *testing.T) {
+	b := ipcclient.Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if got := b.Delay(attempt); got != want {
+			t.Errorf("Expected a delay of %v after attempt %d, but got %v", want, attempt, got)
+		}
+
+		b := b
+		b.Jitter = 0.5
+		for range 100 {
+			if got := b.Delay(attempt); got < want/2 || got > want*3/2 {
+				t.Fatalf("Expected a delay of %v ±50%% after attempt %d, but got %v", want, attempt, got)
+			}
+		}
+	}
+}
+
+// TestConnectNoServer tests that the client fails without prompting when the server is down
+func TestConnectNoServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.sock")
+
+	c := ipcclient.NewIPCClient("client NOSV", "NOSV", "test", ipcclient.WithSocketPath(path))
+	if _, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, ipcclient.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected before Connect, but got %v", err)
+	}
+	if err := c.Connect(); err == nil {
+		t.Errorf("Expected Connect to fail without a server")
+	}
+
+	c = ipcclient.NewIPCClient("client NOSV", "NOSV", "test", ipcclient.WithSocketPath(path),
+		ipcclient.WithReconnect(ipcclient.Backoff{Initial: time.Millisecond, MaxRetries: 3}))
+	if err := c.Connect(); err == nil {
+		t.Errorf("Expected Connect to give up after the retries")
+	}
+
+	c = ipcclient.NewIPCClient("client NOSV", "NOSV", "test", ipcclient.WithSocketPath(path), ipcclient.WithReconnect(ipcclient.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Jitter: 0.2}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.ConnectContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Connect to retry until the context expires, but got %v", err)
+	}
+}
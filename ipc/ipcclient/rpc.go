@@ -0,0 +1,38 @@
+package ipcclient
+
+import (
+	"context"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// Call calls the named method of the server with the request, and returns its response, see ipcserver.Register.
+// The request is encoded with the datatype picked by ipc.EncodeData, and the response decoded with ipc.DecodeData.
+// Errors of the method are returned as an *ipc.Error, like with IPCClient.Call.
+//
+// Example:
+//
+//	count, err := ipcclient.Call[string, int](ctx, client, "rules.count", "sigma")
+func Call[Req, Resp any](ctx context.Context, c *IPCClient, method string, req Req) (Resp, error) {
+	var resp Resp
+
+	dataType, payload, err := ipc.EncodeData(req)
+	if err != nil {
+		return resp, err
+	}
+	data, err := ipc.PackMethod(method, payload)
+	if err != nil {
+		return resp, err
+	}
+
+	res, err := c.Call(ctx, c.request(ipc.MSG_RPC, dataType, data))
+	if err != nil {
+		return resp, err
+	}
+	if len(res.Data) > 0 { // The zero value without a payload
+		if err := ipc.DecodeData(res.Datatype, res.Data, &resp); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,249 @@
+package ipcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// inboxSize is how many messages from the server are kept for AwaitResponse and ClientListen,
+// when they are not replies to a pending request
+const inboxSize = 64
+
+// ErrNotConnected is returned when a request is sent before Connect
+var ErrNotConnected = errors.New("ipcclient: not connected")
+
+// session is one connection to the server.
+//
+// A reader goroutine owns the decoding side of the stream. Replies (ipc.FLAG_REPLY) are routed to the
+// goroutine waiting for the message id, so several goroutines can have requests in flight on the same connection.
+// Other messages from the server are queued in the inbox.
+type session struct {
+	stream     *ipc.Stream
+	identifier [4]byte        // Identifier of the client, for the pongs
+	hb         *ipc.Heartbeat // Health of the connection
+	log        *slog.Logger   // Logger of the client
+
+	subs *subscriptions // Subscriptions of the client, the events are delivered to
+
+	mu        sync.Mutex
+	pending   map[ipc.IPCMessageId]chan ipc.IPCRequest // Requests waiting for a reply, by message id
+	abandoned map[ipc.IPCMessageId]struct{}            // Requests given up on, whose reply is dropped
+	err       error                                    // Why the session ended, set before done is closed
+
+	inbox chan ipc.IPCRequest // Messages that are not replies to a pending request
+	done  chan struct{}       // Closed when the reader stops
+}
+
+func newSession(stream *ipc.Stream, identifier [4]byte, subs *subscriptions, log *slog.Logger) *session {
+	s := &session{
+		stream:     stream,
+		identifier: identifier,
+		hb:         ipc.NewHeartbeat(),
+		log:        log,
+		subs:       subs,
+		pending:    map[ipc.IPCMessageId]chan ipc.IPCRequest{},
+		abandoned:  map[ipc.IPCMessageId]struct{}{},
+		inbox:      make(chan ipc.IPCRequest, inboxSize),
+		done:       make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+// readLoop reads the messages from the server until the connection is closed
+func (s *session) readLoop() {
+	for {
+		msg, err := parseConnection(s.stream)
+		if err != nil {
+			var netErr *net.OpError
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.As(err, &netErr) {
+				err = fmt.Errorf("%w: %v", ipc.ErrDecode, err) // The stream can't be read any further
+			}
+			s.end(err)
+			return
+		}
+		s.hb.Seen()
+
+		// Heartbeats are handled here, they never reach the callers
+		reply := msg.Header.Flags&ipc.FLAG_REPLY != 0
+		switch {
+		case msg.Header.MessageType == ipc.MSG_PING && !reply:
+			go s.stream.Send(ipc.NewPong(&msg, s.identifier)) // Don't block the reader on the write
+			continue
+		case msg.Header.MessageType == ipc.MSG_PONG && reply:
+			s.hb.Pong(&msg)
+			continue
+		}
+
+		if reply && s.deliver(msg) {
+			continue
+		}
+		if msg.Header.MessageType == ipc.MSG_PUBLISH {
+			s.dispatch(msg)
+			continue
+		}
+
+		select {
+		case s.inbox <- msg:
+		default:
+			s.log.Warn("inbox full, dropping message from server", ipc.MessageAttr(&msg))
+		}
+	}
+}
+
+// ended reports whether the reader stopped, and the session with it
+func (s *session) ended() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// keepalive pings the server at the interval, and closes the connection when the server misses too many pongs in a row
+func (s *session) keepalive(interval time.Duration, maxMissed int, nextId func() ipc.IPCMessageId) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if missed := s.hb.Tick(); missed >= maxMissed {
+			s.log.Warn("server missed pongs, closing the connection", "missed", missed)
+			s.stream.Close() // Stops the reader, which fails the pending requests
+			return
+		}
+		if err := s.stream.Send(ipc.NewPing(s.identifier, nextId())); err != nil {
+			s.log.Debug("failed to ping", "err", err)
+			return
+		}
+	}
+}
+
+// deliver hands the reply to the goroutine waiting for it. It returns false if no one is waiting.
+// The replies to abandoned requests are dropped.
+func (s *session) deliver(msg ipc.IPCRequest) bool {
+	id := msg.Header.MessageId
+	s.mu.Lock()
+	ch, ok := s.pending[id]
+	delete(s.pending, id)
+	_, abandoned := s.abandoned[id]
+	delete(s.abandoned, id)
+	s.mu.Unlock()
+
+	if ok {
+		ch <- msg // Buffered, never blocks
+	}
+	return ok || abandoned
+}
+
+// dispatch hands the event to the subscriptions of its topic
+func (s *session) dispatch(msg ipc.IPCRequest) {
+	event, err := ipc.EventFromRequest(&msg)
+	if err != nil {
+		s.log.Warn("invalid publish message", "err", err)
+		return
+	}
+	s.subs.dispatch(event, s.log)
+}
+
+// end fails the pending requests with the error that stopped the reader.
+// The subscriptions are left to the client, which keeps them if it reconnects.
+func (s *session) end(err error) {
+	s.mu.Lock()
+	s.err = err
+	for id, ch := range s.pending {
+		close(ch)
+		delete(s.pending, id)
+	}
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// roundTrip sends the request and waits for the reply with the same message id, until the context is done.
+// If the context is done first, the request is abandoned: the server is told to cancel it, and its reply is dropped.
+func (s *session) roundTrip(ctx context.Context, msg *ipc.IPCRequest) (ipc.IPCRequest, error) {
+	id := msg.Header.MessageId
+	ch := make(chan ipc.IPCRequest, 1)
+
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return ipc.IPCRequest{}, err
+	}
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	if err := s.stream.SendContext(ctx, msg); err != nil {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+		return ipc.IPCRequest{}, err
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return res, s.err
+		}
+		return res, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	if _, ok := s.pending[id]; !ok {
+		s.mu.Unlock()
+		if res, ok := <-ch; ok {
+			return res, nil // Answered in the meantime
+		}
+		return ipc.IPCRequest{}, ctx.Err()
+	}
+	delete(s.pending, id)
+	s.abandoned[id] = struct{}{}
+	s.mu.Unlock()
+
+	go s.cancel(id)
+	return ipc.IPCRequest{}, ctx.Err()
+}
+
+// cancel tells the server to cancel the request, without waiting more than ipc.Timeout for a stuck connection
+func (s *session) cancel(id ipc.IPCMessageId) {
+	ctx, cancel := context.WithTimeout(context.Background(), ipc.Timeout)
+	defer cancel()
+	if err := s.stream.SendContext(ctx, ipc.NewCancel(s.identifier, id)); err != nil {
+		s.log.Debug("failed to cancel request", "message_id", id, "err", err)
+	}
+}
+
+// next returns the next message from the server that is not a reply to a pending request, until the context is done
+func (s *session) next(ctx context.Context) (ipc.IPCRequest, error) {
+	select {
+	case <-ctx.Done():
+		return ipc.IPCRequest{}, ctx.Err()
+	case msg := <-s.inbox:
+		return msg, nil
+	case <-s.done:
+		select {
+		case msg := <-s.inbox: // Drain what was received before the connection closed
+			return msg, nil
+		default:
+			return ipc.IPCRequest{}, s.err
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package ipcclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// subscriptionSize is how many events a subscription buffers before new events are dropped
+const subscriptionSize = 64
+
+// Subscription receives the events published to a topic.
+// The channel is closed when the subscription is cancelled, or the connection to the server is closed.
+// With WithReconnect, subscriptions are kept across reconnections, and only closed when the client gives up.
+type Subscription struct {
+	Topic string
+	C     <-chan ipc.Event // Events published to the topic
+
+	c      chan ipc.Event
+	client *IPCClient
+	once   sync.Once
+}
+
+// subscriptions are the subscriptions of a client, by topic
+type subscriptions struct {
+	mu sync.Mutex
+	m  map[string]map[*Subscription]struct{}
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{m: map[string]map[*Subscription]struct{}{}}
+}
+
+// add adds the subscription. It returns true if it is the first subscription to the topic.
+func (s *subscriptions) add(sub *Subscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := len(s.m[sub.Topic]) == 0
+	if first {
+		s.m[sub.Topic] = map[*Subscription]struct{}{}
+	}
+	s.m[sub.Topic][sub] = struct{}{}
+	return first
+}
+
+// remove removes the subscription and closes its channel. It returns true if it was the last subscription to the topic.
+func (s *subscriptions) remove(sub *Subscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.m[sub.Topic][sub]; !ok {
+		return false // Already closed with the connection
+	}
+	delete(s.m[sub.Topic], sub)
+	close(sub.c)
+	if len(s.m[sub.Topic]) == 0 {
+		delete(s.m, sub.Topic)
+		return true
+	}
+	return false
+}
+
+// topics returns the subscribed topics, sorted
+func (s *subscriptions) topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := make([]string, 0, len(s.m))
+	for topic := range s.m {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics
+}
+
+// dispatch hands the event to the subscriptions of its topic.
+// Events are dropped for subscriptions that don't keep up, so one slow subscriber doesn't stall the connection.
+func (s *subscriptions) dispatch(event ipc.Event, log *slog.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.m[event.Topic] {
+		select {
+		case sub.c <- event:
+		default:
+			log.Warn("subscription full, dropping event", "topic", event.Topic)
+		}
+	}
+}
+
+// closeAll closes the subscriptions, when the connection is closed for good
+func (s *subscriptions) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, subs := range s.m {
+		for sub := range subs {
+			close(sub.c)
+		}
+		delete(s.m, topic)
+	}
+}
+
+// Subscribe subscribes to the topic, and returns the subscription the events are delivered to.
+// Events are dropped if the subscription's channel is full, so keep reading it.
+//
+// Example:
+//
+//	sub, err := client.Subscribe("threat_intel.inserted")
+//	if err != nil {
+//		return err
+//	}
+//	defer sub.Unsubscribe()
+//	for event := range sub.C {
+//		fmt.Println("New row:", event.Message.StringData)
+//	}
+func (c *IPCClient) Subscribe(topic string) (*Subscription, error) {
+	if err := ipc.ValidateTopic(topic); err != nil {
+		return nil, err
+	}
+	sess, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan ipc.Event, subscriptionSize)
+	sub := &Subscription{Topic: topic, C: ch, c: ch, client: c}
+
+	if c.subs.add(sub) {
+		if err := c.control(sess, c.request(ipc.MSG_SUBSCRIBE, ipc.DATA_TEXT, []byte(topic))); err != nil {
+			c.subs.remove(sub)
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Unsubscribe cancels the subscription and closes its channel.
+// The server is told to stop sending the topic when it was the last subscription of the client to the topic.
+func (sub *Subscription) Unsubscribe() error {
+	var err error
+	sub.once.Do(func() {
+		c := sub.client
+		if !c.subs.remove(sub) {
+			return
+		}
+		sess, serr := c.session()
+		if serr != nil {
+			err = serr
+			return
+		}
+		err = c.control(sess, c.request(ipc.MSG_UNSUBSCRIBE, ipc.DATA_TEXT, []byte(sub.Topic)))
+	})
+	return err
+}
+
+// Publish publishes the payload to the topic. Every module subscribed to the topic receives it, including this one.
+func (c *IPCClient) Publish(topic string, dataType ipc.DataType, payload []byte) error {
+	data, err := ipc.PackTopic(topic, payload)
+	if err != nil {
+		return err
+	}
+	sess, err := c.session()
+	if err != nil {
+		return err
+	}
+	return c.control(sess, c.request(ipc.MSG_PUBLISH, dataType, data))
+}
+
+// control sends a protocol message and waits for the server to acknowledge it
+func (c *IPCClient) control(sess *session, req *ipc.IPCRequest) error {
+	ctx, cancel := c.callContext(context.Background())
+	defer cancel()
+
+	res, err := sess.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	if res.Header.MessageType == ipc.MSG_ERROR {
+		return ipc.ErrorFromMessage(&res)
+	}
+	if res.Header.MessageType != ipc.MSG_ACK {
+		return fmt.Errorf("ipcclient: unexpected message type 0x%02x", res.Header.MessageType)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// blockingServer starts a server whose handler blocks on "block" until the request is cancelled,
+// and returns the socket path and the causes of the cancellations
+func blockingServer(t *testing.T) (string, <-chan error) {
+	t.Helper()
+
+	cancelled := make(chan error, 1)
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			if req.Message.StringData != "block" {
+				return echo(ctx, req)
+			}
+			select {
+			case <-ctx.Done():
+				cancelled <- context.Cause(ctx)
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("not cancelled")
+			}
+		})
+	})
+	return path, cancelled
+}
+
+func expectCancelled(t *testing.T, cancelled <-chan error) {
+	t.Helper()
+
+	select {
+	case cause := <-cancelled:
+		if !errors.Is(cause, ipc.ErrCancelled) {
+			t.Errorf("Expected the handler to be cancelled by the client, but got %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the handler to be cancelled")
+	}
+}
+
+// TestCallDeadline tests that a call gives up at its deadline, and that the server abandons the request
+func TestCallDeadline(t *testing.T) {
+	path, cancelled := blockingServer(t)
+	c := connect(t, path, "CALL")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, c.CreateReq("block", ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ipc.ErrTimeout) {
+		t.Fatalf("Expected DeadlineExceeded and ErrTimeout, but got %v", err)
+	}
+	expectCancelled(t, cancelled)
+
+	res, err := c.Call(context.Background(), c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT))
+	if err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo after the abandoned call, but got %q, %v", res.StringData, err)
+	}
+
+	// The reply to the abandoned call is dropped, it doesn't end up in the inbox
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if msg, err := c.AwaitResponseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected no message from the server, but got %q, %v", msg.StringData, err)
+	}
+}
+
+// TestCallCancel tests that cancelling the context of a call cancels the request on the server
+func TestCallCancel(t *testing.T) {
+	path, cancelled := blockingServer(t)
+	c := connect(t, path, "CCAN")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.Call(ctx, c.CreateReq("block", ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Canceled, but got %v", err)
+	}
+	expectCancelled(t, cancelled)
+}
+
+// TestClientTimeout tests that the timeout of the client applies to SendIPCMessage
+func TestClientTimeout(t *testing.T) {
+	path, cancelled := blockingServer(t)
+	c := connect(t, path, "CTIM", ipcclient.WithTimeout(50*time.Millisecond))
+
+	_, err := c.SendIPCMessage(c.CreateReq("block", ipc.MSG_MSG, ipc.DATA_TEXT))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, but got %v", err)
+	}
+	var ipcErr *ipc.Error
+	if !errors.Is(err, ipc.ErrTimeout) || !errors.As(err, &ipcErr) || !ipcErr.Retryable {
+		t.Errorf("Expected a retryable ErrTimeout, but got %v", err)
+	}
+	expectCancelled(t, cancelled)
+
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo, but got %q, %v", res.StringData, err)
+	}
+}
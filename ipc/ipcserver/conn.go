@@ -0,0 +1,300 @@
+package ipcserver
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// connKey is the context key of the connection a request was received on
+type connKey struct{}
+
+// connection is a connected client of the server
+type connection struct {
+	server *IPCServer
+	c      net.Conn
+	stream *ipc.Stream // Encoder and decoder for the life of the connection
+
+	ctx     context.Context // Cancelled when the connection is closed
+	cancel  context.CancelFunc
+	info    *ConnInfo         // Set once the handshake is completed, guarded by the server's mu for readers outside the connection
+	peer    *Credentials      // Credentials of the peer process, if available
+	cert    *x509.Certificate // Certificate of the client over mutual TLS, set by the TLS handshake
+	hb      *ipc.Heartbeat    // Health of the connection
+	log     *slog.Logger      // Logger of the server, with the peer and module of the connection
+	module  *moduleDef        // Definition of the module, nil if it has none. Only used by the read loop.
+	limiter *limiter          // Rate limit of the module, nil if unlimited. Only used by the read loop.
+
+	wg       sync.WaitGroup // Requests of this connection being handled
+	reqMu    sync.Mutex
+	requests map[ipc.IPCMessageId]*request // Requests being handled, by message id, for MSG_CANCEL
+
+	jsonrpc *jsonrpcConn // Set if the connection speaks JSON-RPC, before the handshake
+
+	events     chan *ipc.IPCRequest // Published events waiting to be sent to the subscriber
+	eventsOnce sync.Once            // Starts the sender of the events on the first subscription
+}
+
+// request is a request being handled on a connection
+type request struct {
+	cancel context.CancelCauseFunc
+}
+
+func (s *IPCServer) newConnection(c net.Conn) *connection {
+	ctx, cancel := context.WithCancel(context.Background())
+	cn := &connection{
+		server: s,
+		c:      c,
+		stream: ipc.NewStream(c, s.codec),
+		ctx:    ctx,
+		cancel: cancel,
+		hb:     ipc.NewHeartbeat(),
+		log:    s.logger(),
+
+		requests: map[ipc.IPCMessageId]*request{},
+		events:   make(chan *ipc.IPCRequest, eventQueueSize),
+	}
+	cn.ctx = context.WithValue(ctx, connKey{}, cn)
+
+	if _, ok := c.(*net.UnixConn); !ok {
+		cn.log = cn.log.With("remote", c.RemoteAddr().String()) // There are no peer credentials over TCP
+		return cn
+	}
+
+	peer, err := peerCredentials(c)
+	if err != nil {
+		cn.log.Debug("peer credentials not available", "err", err)
+	} else {
+		cn.peer = peer
+		cn.log = cn.log.With(slog.Group("peer", "uid", peer.UID, "gid", peer.GID, "pid", peer.PID))
+	}
+
+	return cn
+}
+
+// serve handles the incoming connection.
+// Requests are read one by one, but handled concurrently, bounded by the in-flight limit of the server.
+func (cn *connection) serve() {
+	s := cn.server
+	defer cn.close()
+
+	cn.log.Debug("serving connection")
+
+	if err := cn.tlsHandshake(); err != nil {
+		if !s.shuttingDown() {
+			cn.log.Warn("TLS handshake failed", "err", err)
+		}
+		return
+	}
+
+	// The module has ipc.Timeout to complete the handshake, so idle connections don't hold a slot forever
+	cn.c.SetReadDeadline(time.Now().Add(ipc.Timeout))
+
+serve:
+	for {
+		request, err := parseConnection(cn.stream)
+		if err != nil {
+			if err == io.EOF {
+				cn.log.Debug("connection closed by client")
+			} else if cn.info == nil && errors.Is(err, os.ErrDeadlineExceeded) && !s.shuttingDown() {
+				cn.log.Warn("no handshake in time, closing the connection", "timeout", ipc.Timeout)
+			} else if !s.shuttingDown() {
+				cn.log.Error("failed to decode request", "err", err)
+			}
+			break
+		}
+		cn.hb.Seen()
+		s.logMessage(cn.log, "request received", &request)
+
+		switch request.Header.MessageType {
+		case ipc.MSG_CONN:
+			response, err := cn.handshake(&request)
+			if err != nil {
+				cn.log.Warn("handshake refused", "err", err)
+				response = NewErrorResponse(&request, err)
+			}
+			if err := cn.respond(response); err != nil {
+				cn.log.Error("failed to respond", "err", err)
+				break serve
+			}
+			if cn.info == nil {
+				break serve // Refused connections are closed
+			}
+			cn.clearDeadline()
+			if s.heartbeat > 0 {
+				go cn.keepalive()
+			}
+			continue
+
+		case ipc.MSG_DISCONNECT:
+			if cn.authorize(&request) == nil {
+				s.serve(cn.ctx, &request)
+			}
+			cn.log.Debug("client disconnected")
+			break serve
+		}
+
+		if err := cn.authorize(&request); err != nil {
+			cn.log.Warn("rejected request", ipc.MessageAttr(&request), "err", err)
+			if err := cn.respond(NewErrorResponse(&request, err)); err != nil {
+				break
+			}
+			continue
+		}
+
+		// Heartbeats are answered right away, they don't wait for a slot like the requests
+		switch {
+		case request.Header.MessageType == ipc.MSG_PING:
+			if err := cn.respond(ipc.NewPong(&request, s.id())); err != nil {
+				break serve
+			}
+			continue
+		case request.Header.MessageType == ipc.MSG_PONG && request.Header.Flags&ipc.FLAG_REPLY != 0:
+			cn.hb.Pong(&request)
+			continue
+		case request.Header.MessageType == ipc.MSG_CANCEL:
+			cn.cancelRequest(request.Header.MessageId)
+			continue
+		}
+
+		ctx, done := cn.startRequest(request.Header.MessageId)
+		s.inflight.acquire()
+		cn.wg.Add(1)
+		go func(req ipc.IPCRequest) {
+			defer cn.wg.Done()
+			defer s.inflight.release()
+			defer done()
+
+			// Process the request...
+			response := s.serve(ctx, &req)
+
+			// Finally, respond to the client
+			if err := cn.respond(response); err != nil {
+				cn.log.Error("failed to respond", "err", err)
+				cn.c.Close() // Unblocks the read loop
+			}
+		}(request)
+	}
+
+	// Let the requests finish before the connection is closed
+	cn.wg.Wait()
+
+	// Connections closed by a forced shutdown have their context cancelled already
+	if s.shuttingDown() && cn.ctx.Err() == nil {
+		if err := cn.disconnect("server shutting down"); err != nil {
+			cn.log.Debug("failed to send disconnect message", "err", err)
+		}
+	}
+}
+
+// startRequest returns the context of the request with the message id, cancelled by a MSG_CANCEL with the same id.
+// The returned function must be called once the request is answered.
+func (cn *connection) startRequest(id ipc.IPCMessageId) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(cn.ctx)
+	r := &request{cancel: cancel}
+
+	cn.reqMu.Lock()
+	cn.requests[id] = r // A request reusing the id of one in flight takes its place
+	cn.reqMu.Unlock()
+
+	return ctx, func() {
+		cn.reqMu.Lock()
+		if cn.requests[id] == r {
+			delete(cn.requests, id)
+		}
+		cn.reqMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelRequest cancels the context of the request with the message id, if it is still being handled.
+// The handler should return as soon as it can, its response is sent as usual.
+func (cn *connection) cancelRequest(id ipc.IPCMessageId) {
+	cn.reqMu.Lock()
+	r, ok := cn.requests[id]
+	cn.reqMu.Unlock()
+
+	if !ok {
+		cn.log.Debug("nothing to cancel, the request was answered", "message_id", id)
+		return
+	}
+	cn.log.Debug("request cancelled by the client", "message_id", id)
+	r.cancel(ipc.ErrCancelled)
+}
+
+// clearDeadline removes the read deadline of the handshake, unless the server is shutting down,
+// as Shutdown stops the connections from reading with a deadline too
+func (cn *connection) clearDeadline() {
+	cn.server.mu.Lock()
+	defer cn.server.mu.Unlock()
+	if !cn.server.shuttingDown() {
+		cn.c.SetReadDeadline(time.Time{})
+	}
+}
+
+// name returns the name of the module on the connection, for logging
+func (cn *connection) name() string {
+	if cn.info != nil {
+		return cn.info.Module
+	}
+	return "unidentified client"
+}
+
+// keepalive pings the client at the heartbeat interval of the server,
+// and closes the connection when the client misses too many pongs in a row
+func (cn *connection) keepalive() {
+	s := cn.server
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cn.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if missed := cn.hb.Tick(); missed >= s.maxMissed {
+			cn.log.Warn("peer missed pongs, closing the connection", "missed", missed)
+			cn.close()
+			return
+		}
+
+		ping := ipc.NewPing(s.id(), ipc.IPCMessageId(s.pingId.Add(1)))
+		ping.MessageSignature = []byte(s.identifier)
+		if err := cn.stream.Send(ping); err != nil {
+			cn.log.Debug("failed to ping", "err", err)
+			return
+		}
+	}
+}
+
+// respond writes the response to the client
+func (cn *connection) respond(response *ipc.IPCRequest) error {
+	return cn.server.respond(cn.stream, response)
+}
+
+// disconnect tells the client that the server is closing the connection, and why.
+// JSON-RPC clients get a JSONRPC_DISCONNECT notification instead of the MSG_DISCONNECT message.
+func (cn *connection) disconnect(reason string) error {
+	if cn.jsonrpc != nil {
+		return cn.jsonrpc.notify(JSONRPC_DISCONNECT, reason)
+	}
+	msg := newMessage(cn.server.id(), ipc.MSG_DISCONNECT, ipc.DATA_TEXT, []byte(reason))
+	return cn.respond(msg)
+}
+
+// close cancels the requests of the connection and closes it
+func (cn *connection) close() {
+	cn.server.topics.unsubscribeAll(cn)
+	cn.cancel()
+	cn.c.Close()
+}
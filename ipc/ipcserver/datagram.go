@@ -0,0 +1,175 @@
+package ipcserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// datagramSocket is the unixgram socket of a server in datagram mode
+type datagramSocket struct {
+	path   string
+	conn   *net.UnixConn
+	lock   *os.File           // Lock file next to the socket, nil for abstract sockets
+	ctx    context.Context    // Context of the requests received on the socket
+	cancel context.CancelFunc // Cancels the requests, when the server is shut down
+
+	limiters map[string]*moduleLimiter // Rate limits of the modules, only used by the read loop
+}
+
+// moduleLimiter is the rate limit of a module on the datagram socket, and the definition it was made from
+type moduleLimiter struct {
+	def     *moduleDef
+	limiter *limiter
+}
+
+// listenDatagram creates the datagram socket of the server, like listen does for the stream socket
+func (s *IPCServer) listenDatagram() (*datagramSocket, error) {
+	d := &datagramSocket{path: s.dgramPath, limiters: make(map[string]*moduleLimiter)}
+	addr := &net.UnixAddr{Name: d.path, Net: AF_DGRAM}
+
+	var err error
+	if ipc.IsAbstractSock(d.path) {
+		d.conn, err = net.ListenUnixgram(AF_DGRAM, addr)
+	} else {
+		d.conn, err = s.listenDatagramFile(d, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d, nil
+}
+
+func (s *IPCServer) listenDatagramFile(d *datagramSocket, addr *net.UnixAddr) (*net.UnixConn, error) {
+	gid, err := s.socketGid()
+	if err != nil {
+		return nil, err
+	}
+	if err := socketDir(filepath.Dir(d.path), gid); err != nil {
+		return nil, err
+	}
+
+	if d.lock, err = lockSocket(AF_DGRAM, d.path); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUnixgram(AF_DGRAM, addr)
+	if err == nil {
+		if err = s.setSocketMode(d.path, gid); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		unlockSocket(d.lock)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// close stops receiving datagrams, and removes the socket
+func (d *datagramSocket) close() error {
+	err := d.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	if !ipc.IsAbstractSock(d.path) {
+		if rerr := os.Remove(d.path); rerr != nil && !os.IsNotExist(rerr) {
+			err = errors.Join(err, rerr)
+		}
+	}
+	unlockSocket(d.lock)
+	return err
+}
+
+// serveDatagrams receives the datagrams until the socket is closed.
+// Every datagram is a request on its own, handled like the requests of the connections, but never answered.
+func (s *IPCServer) serveDatagrams(d *datagramSocket) {
+	defer s.connWg.Done()
+	log := s.logger().With("transport", ipc.DGRAM)
+
+	buf := make([]byte, ipc.MAX_DATAGRAM_SIZE+1) // One more byte to tell oversized datagrams apart
+	for {
+		n, _, err := d.conn.ReadFromUnix(buf)
+		if err != nil {
+			if !s.shuttingDown() {
+				log.Error("failed to receive datagram", "err", err)
+			}
+			return
+		}
+		if n > ipc.MAX_DATAGRAM_SIZE {
+			log.Warn("dropping datagram", "err", ipc.ErrDatagramTruncated, "limit", ipc.MAX_DATAGRAM_SIZE)
+			continue
+		}
+
+		req, err := ipc.DecodeDatagram(s.codec, buf[:n])
+		if err != nil {
+			log.Warn("dropping datagram", "err", err)
+			continue
+		}
+		s.logMessage(log, "datagram received", &req)
+
+		name, ok := d.verify(log, &req)
+		if !ok {
+			continue
+		}
+		s.inflight.acquire()
+		s.connWg.Add(1)
+		go func() {
+			defer s.connWg.Done()
+			defer s.inflight.release()
+			s.handleDatagram(d.ctx, log.With("module", name), name, &req)
+		}()
+	}
+}
+
+// verify returns the module that sent the datagram, and false if the datagram must be dropped.
+// There is no handshake: the identifier of the header must be a known module, and modules restricted to users
+// or groups are refused, as the credentials of the sender of a datagram are not available.
+// The rate limit of the module applies to all the datagrams it sends, like it does to a connection.
+func (d *datagramSocket) verify(log *slog.Logger, req *ipc.IPCRequest) (string, bool) {
+	name, ok := moduleByIdentifier(req.Header.Identifier)
+	if !ok {
+		log.Warn("dropping datagram from an unknown module", ipc.MessageAttr(req))
+		return "", false
+	}
+	if !moduleAllowlist(name).Empty() {
+		log.Warn("dropping datagram, the credentials of the module can't be verified", "module", name)
+		return "", false
+	}
+
+	def := moduleDefinition(name)
+	if def != nil && !def.allows(req.Header.MessageType) {
+		log.Warn("dropping datagram, message type not allowed", "module", name, ipc.MessageAttr(req))
+		return "", false
+	}
+	// The definition may have been reloaded since the last datagram
+	ml := d.limiters[name]
+	if ml == nil || ml.def != def {
+		ml = &moduleLimiter{def: def}
+		if def != nil {
+			ml.limiter = newLimiter(def.RateLimit)
+		}
+		d.limiters[name] = ml
+	}
+	if !ml.limiter.allow() {
+		log.Warn("dropping datagram, rate limit exceeded", "module", name, "rate", ml.limiter.rate, ipc.MessageAttr(req))
+		return "", false
+	}
+	return name, true
+}
+
+// handleDatagram passes a verified datagram of the module to the handler.
+// The logger is in the context of the request, for the middleware to log with it.
+func (s *IPCServer) handleDatagram(ctx context.Context, log *slog.Logger, name string, req *ipc.IPCRequest) {
+	ctx = context.WithValue(ctx, loggerKey{}, log)
+	ctx = context.WithValue(ctx, connInfoKey{}, &ConnInfo{Module: name, Identifier: req.Header.Identifier})
+	if response := s.serve(ctx, req); response.Header.MessageType == ipc.MSG_ERROR {
+		log.Warn("datagram failed", ipc.MessageAttr(req), "err", ipc.ErrorFromMessage(response))
+	}
+}
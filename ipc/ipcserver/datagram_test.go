@@ -0,0 +1,189 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// datagramServer starts a server in datagram mode, and returns the path of the datagram socket and the received messages
+func datagramServer(t *testing.T) (string, string, <-chan string) {
+	t.Helper()
+
+	received := make(chan string, 16)
+	dgram := filepath.Join(t.TempDir(), "test.dgram")
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			info, _ := ipcserver.ConnInfoFromContext(ctx)
+			received <- info.Module + ": " + req.Message.StringData
+			return echo(ctx, req)
+		})
+	}, ipcserver.WithDatagramSocket(dgram))
+	return path, dgram, received
+}
+
+// datagramClient returns a client that sends datagrams as the module, without connecting
+func datagramClient(t *testing.T, path, dgram, identifier string) *ipcclient.IPCClient {
+	t.Helper()
+
+	c := ipcclient.NewIPCClient("client "+identifier, identifier, "test", ipcclient.WithSocketPath(path), ipcclient.WithDatagramSocket(dgram))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func expectDatagram(t *testing.T, received <-chan string, want string) {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("Expected %q, but got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %q", want)
+	}
+}
+
+// TestDatagram tests that datagrams are handled like requests, without a connection
+func TestDatagram(t *testing.T) {
+	path, dgram, received := datagramServer(t)
+
+	ipcserver.AddModule("dgram", []byte("DGRM"))
+	c := datagramClient(t, path, dgram, "DGRM")
+	for _, msg := range []string{"first", "second"} {
+		if err := c.SendDatagram(c.CreateReq(msg, ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+			t.Fatalf("Failed to send datagram: %v", err)
+		}
+		expectDatagram(t, received, "dgram: "+msg)
+	}
+
+	if err := datagramClient(t, path, "", "DGRM").SendDatagram(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, ipcclient.ErrNoDatagramSocket) {
+		t.Errorf("Expected ErrNoDatagramSocket without a datagram socket, but got %v", err)
+	}
+}
+
+// TestDatagramSize tests that oversized messages are refused by the client, and dropped by the server
+func TestDatagramSize(t *testing.T) {
+	path, dgram, received := datagramServer(t)
+
+	ipcserver.AddModule("dgram", []byte("DGRM"))
+	c := datagramClient(t, path, dgram, "DGRM")
+	large := strings.Repeat("x", ipc.MAX_DATAGRAM_SIZE)
+	if err := c.SendDatagram(c.CreateReq(large, ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, ipc.ErrDatagramTooLarge) {
+		t.Errorf("Expected ErrDatagramTooLarge, but got %v", err)
+	}
+
+	conn, err := net.Dial(ipc.AF_DGRAM, dgram)
+	if err != nil {
+		t.Fatalf("Failed to dial the datagram socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(make([]byte, ipc.MAX_DATAGRAM_SIZE+1)); err != nil {
+		t.Fatalf("Failed to send the oversized datagram: %v", err)
+	}
+	if _, err := conn.Write([]byte("garbage")); err != nil {
+		t.Fatalf("Failed to send the invalid datagram: %v", err)
+	}
+
+	if err := c.SendDatagram(c.CreateReq("after", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+		t.Fatalf("Failed to send datagram: %v", err)
+	}
+	expectDatagram(t, received, "dgram: after")
+}
+
+// TestDatagramModules tests that the datagrams of unknown modules, and of modules restricted to users, are dropped
+func TestDatagramModules(t *testing.T) {
+	path, dgram, received := datagramServer(t)
+
+	ipcserver.AddModule("dgram", []byte("DGRM"))
+	err := ipcserver.RegisterModule(ipcserver.Module{Name: "restricted", Identifier: "RSTR", UID: ipcserver.IDs{strconv.Itoa(os.Getuid())}})
+	if err != nil {
+		t.Fatalf("Failed to register module: %v", err)
+	}
+
+	for _, id := range []string{"UNKN", "RSTR"} {
+		c := datagramClient(t, path, dgram, id)
+		if err := c.SendDatagram(c.CreateReq("dropped", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+			t.Fatalf("Failed to send datagram: %v", err)
+		}
+	}
+
+	c := datagramClient(t, path, dgram, "DGRM")
+	if err := c.SendDatagram(c.CreateReq("kept", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+		t.Fatalf("Failed to send datagram: %v", err)
+	}
+	expectDatagram(t, received, "dgram: kept")
+
+	select {
+	case got := <-received:
+		t.Errorf("Expected the other datagrams to be dropped, but got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestDatagramRateLimit tests that the rate limit of a module applies to its datagrams
+func TestDatagramRateLimit(t *testing.T) {
+	path, dgram, received := datagramServer(t)
+
+	err := ipcserver.RegisterModule(ipcserver.Module{Name: "dlimited", Identifier: "DLIM", RateLimit: ipcserver.RateLimit{Rate: 0.001, Burst: 2}})
+	if err != nil {
+		t.Fatalf("Failed to register module: %v", err)
+	}
+
+	c := datagramClient(t, path, dgram, "DLIM")
+	for _, msg := range []string{"first", "second", "dropped"} {
+		if err := c.SendDatagram(c.CreateReq(msg, ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+			t.Fatalf("Failed to send datagram: %v", err)
+		}
+	}
+	got := []string{<-received, <-received}
+	slices.Sort(got)
+	if want := []string{"dlimited: first", "dlimited: second"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %q within the burst, but got %q", want, got)
+	}
+
+	select {
+	case got := <-received:
+		t.Errorf("Expected the datagram over the rate limit to be dropped, but got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestDatagramLogger tests that the middleware logs the datagrams with the logger of the server
+func TestDatagramLogger(t *testing.T) {
+	var buf logBuffer
+	dgram := filepath.Join(t.TempDir(), "test.dgram")
+	received := make(chan string, 1)
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.Use(ipcserver.Timing())
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			received <- req.Message.StringData
+			return echo(ctx, req)
+		})
+	}, ipcserver.WithDatagramSocket(dgram), ipcserver.WithLogger(debugLogger(&buf)))
+
+	ipcserver.AddModule("dlogged", []byte("DLOG"))
+	c := datagramClient(t, path, dgram, "DLOG")
+	if err := c.SendDatagram(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+		t.Fatalf("Failed to send datagram: %v", err)
+	}
+	expectDatagram(t, received, "hello")
+
+	eventually(t, "the request to be logged", func() bool {
+		return strings.Contains(buf.String(), "request handled")
+	})
+	if out := buf.String(); !strings.Contains(out, "module=dlogged") {
+		t.Errorf("Expected the request to be logged with its module, but got %q", out)
+	}
+}
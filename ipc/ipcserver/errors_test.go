@@ -0,0 +1,53 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestErrorResponses tests that the failures of the server reach the client as typed errors
+func TestErrorResponses(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleDataFunc(ipc.MSG_MSG, ipc.DATA_TEXT, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			return nil, errors.New("database is locked")
+		})
+		s.HandleDataFunc(ipc.MSG_MSG, ipc.DATA_INT, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			ctx, cancel := context.WithTimeout(ctx, 0)
+			defer cancel()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	})
+	c := connect(t, path, "ERRS")
+
+	_, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT))
+	var ipcErr *ipc.Error
+	if !errors.As(err, &ipcErr) || ipcErr.Code != ipc.CODE_HANDLER || ipcErr.Message != "database is locked" || ipcErr.Retryable {
+		t.Errorf("Expected the handler failure, but got %#v", err)
+	}
+
+	_, err = c.SendIPCMessage(c.CreateReq("1", ipc.MSG_MSG, ipc.DATA_INT))
+	if !errors.Is(err, ipc.ErrTimeout) || !errors.As(err, &ipcErr) || !ipcErr.Retryable {
+		t.Errorf("Expected a retryable timeout, but got %#v", err)
+	}
+
+	_, err = c.SendIPCMessage(c.CreateReq("{}", ipc.MSG_MSGACK, ipc.DATA_JSON))
+	if !errors.Is(err, ipc.ErrNoHandler) || !errors.As(err, &ipcErr) || ipcErr.Details["message_type"] != "0x05" {
+		t.Errorf("Expected no handler for message type 0x05, but got %#v", err)
+	}
+}
+
+// TestUnknownModuleError tests that a refused handshake tells the client why
+func TestUnknownModuleError(t *testing.T) {
+	path := startServer(t, nil)
+
+	c := ipcclient.NewIPCClient("client NOPE", "NOPE", "test", ipcclient.WithSocketPath(path))
+	if err := c.Connect(); !errors.Is(err, ipc.ErrUnknownModule) {
+		t.Errorf("Expected ErrUnknownModule, but got %v", err)
+	}
+}
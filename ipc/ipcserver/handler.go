@@ -0,0 +1,166 @@
+package ipcserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pynezz/pynezzentials"
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// ErrNoHandler is returned by the ServeMux when no handler is registered for a request
+var ErrNoHandler = ipc.ErrNoHandler
+
+// Handler responds to an IPC request.
+//
+// The returned request is sent back to the client as the response.
+// A nil response with a nil error acknowledges the request with MSG_ACK,
+// while a non-nil error is sent back as a MSG_ERROR response (see NewErrorResponse).
+type Handler interface {
+	ServeIPC(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)
+}
+
+// HandlerFunc lets an ordinary function be used as a Handler.
+//
+// Example:
+//
+//	server.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+//		return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte("Hello, client!")), nil
+//	})
+type HandlerFunc func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)
+
+// ServeIPC calls f(ctx, req)
+func (f HandlerFunc) ServeIPC(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	return f(ctx, req)
+}
+
+type muxKey struct {
+	msgType  byte
+	dataType ipc.DataType
+}
+
+// ServeMux is a request router for IPC requests.
+// It matches the message type of the header (MSG_MSG, MSG_PING, ...) and optionally the datatype of the message.
+// Handlers registered for a message type and datatype take precedence over handlers registered for the message type only.
+//
+// Structured requests carrying metadata are first matched on the method and destination object of the metadata,
+// see HandleResource. MSG_RPC requests are only matched on their method name, see HandleMethod.
+type ServeMux struct {
+	mu        sync.RWMutex
+	types     map[byte]Handler        // message type -> handler
+	specific  map[muxKey]Handler      // message type + datatype -> handler
+	resources map[resourceKey]Handler // method + destination object -> handler
+	methods   map[string]Handler      // rpc method -> handler
+}
+
+// NewServeMux allocates and returns a new, empty ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{
+		types:     map[byte]Handler{},
+		specific:  map[muxKey]Handler{},
+		resources: map[resourceKey]Handler{},
+		methods:   map[string]Handler{},
+	}
+}
+
+// Handle registers the handler for the given message type.
+func (m *ServeMux) Handle(msgType byte, h Handler) {
+	if h == nil {
+		panic("ipcserver: nil handler")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.types[msgType] = h
+}
+
+// HandleFunc registers the handler function for the given message type.
+func (m *ServeMux) HandleFunc(msgType byte, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	m.Handle(msgType, HandlerFunc(f))
+}
+
+// HandleData registers the handler for the given message type and datatype.
+func (m *ServeMux) HandleData(msgType byte, dataType ipc.DataType, h Handler) {
+	if h == nil {
+		panic("ipcserver: nil handler")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.specific[muxKey{msgType, dataType}] = h
+}
+
+// HandleDataFunc registers the handler function for the given message type and datatype.
+func (m *ServeMux) HandleDataFunc(msgType byte, dataType ipc.DataType, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	m.HandleData(msgType, dataType, HandlerFunc(f))
+}
+
+// Handler returns the handler to use for the given request, and whether one was found.
+func (m *ServeMux) Handler(req *ipc.IPCRequest) (Handler, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if h, ok := m.specific[muxKey{req.Header.MessageType, req.Message.Datatype}]; ok {
+		return h, true
+	}
+	h, ok := m.types[req.Header.MessageType]
+	return h, ok
+}
+
+// ServeIPC dispatches the request to the handler registered for it.
+// Requests without a matching handler return an error wrapping ErrNoHandler.
+func (m *ServeMux) ServeIPC(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	if req.Header.MessageType == ipc.MSG_RPC {
+		return m.serveMethod(ctx, req)
+	}
+
+	h, ctx, ok, err := m.resource(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return h.ServeIPC(ctx, req)
+	}
+
+	h, ok = m.Handler(req)
+	if !ok {
+		msgType, dataType := fmt.Sprintf("0x%02x", req.Header.MessageType), fmt.Sprintf("0x%02x", req.Message.Datatype)
+		err := ErrNoHandler.With("message_type", msgType).With("datatype", dataType)
+		return nil, fmt.Errorf("%w: message type %s, datatype %s", err, msgType, dataType)
+	}
+	return h.ServeIPC(ctx, req)
+}
+
+// NewResponse creates a response to the given request.
+// The identifier and message id of the request header are kept, and the response is flagged as a reply,
+// so the client can tell which request the response belongs to.
+func NewResponse(req *ipc.IPCRequest, msgType byte, dataType ipc.DataType, data []byte) *ipc.IPCRequest {
+	response := newMessage(req.Header.Identifier, msgType, dataType, data)
+	response.Header.Flags |= ipc.FLAG_REPLY
+	response.Header.MessageId = req.Header.MessageId
+	return response
+}
+
+// NewErrorResponse creates the MSG_ERROR response to the request.
+// The error is sent in its structured form, see ipc.Error.
+func NewErrorResponse(req *ipc.IPCRequest, err error) *ipc.IPCRequest {
+	msg := ipc.NewErrorMessage(err)
+	return NewResponse(req, ipc.MSG_ERROR, msg.Datatype, msg.Data)
+}
+
+// newMessage creates a message from the server with the given identifier in the header.
+// The message signature is set by the server when the message is sent.
+func newMessage(identifier [4]byte, msgType byte, dataType ipc.DataType, data []byte) *ipc.IPCRequest {
+	return &ipc.IPCRequest{
+		Header: ipc.IPCHeader{
+			Identifier:  identifier,
+			MessageType: msgType,
+		},
+		Message: ipc.IPCMessage{
+			Datatype:   dataType,
+			Data:       data,
+			StringData: string(data),
+		},
+		Timestamp:  pynezzentials.UnixNanoTimestamp(),
+		Checksum32: int(crc(data)),
+	}
+}
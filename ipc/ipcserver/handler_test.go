@@ -0,0 +1,66 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+func reply(text string) ipcserver.HandlerFunc {
+	return func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+		return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte(text)), nil
+	}
+}
+
+// TestServeMux tests that the ServeMux dispatches on message type and datatype
+func TestServeMux(t *testing.T) {
+	mux := ipcserver.NewServeMux()
+	mux.Handle(ipc.MSG_MSG, reply("any"))
+	mux.HandleData(ipc.MSG_MSG, ipc.DATA_JSON, reply("json"))
+	mux.Handle(ipc.MSG_PING, reply("ping"))
+
+	tests := []struct {
+		msgType  byte
+		dataType ipc.DataType
+		expected string
+	}{
+		{ipc.MSG_MSG, ipc.DATA_TEXT, "any"},
+		{ipc.MSG_MSG, ipc.DATA_JSON, "json"},
+		{ipc.MSG_PING, ipc.DATA_JSON, "ping"},
+	}
+
+	for _, test := range tests {
+		req := &ipc.IPCRequest{
+			Header:  ipc.IPCHeader{Identifier: [4]byte{'T', 'E', 'S', 'T'}, MessageType: test.msgType},
+			Message: ipc.IPCMessage{Datatype: test.dataType},
+		}
+		res, err := mux.ServeIPC(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Unexpected error for message type 0x%02x: %v", test.msgType, err)
+		}
+		if res.Message.StringData != test.expected {
+			t.Errorf("For message type 0x%02x and datatype %d, expected %q, but got %q", test.msgType, test.dataType, test.expected, res.Message.StringData)
+		}
+		if res.Header.Identifier != req.Header.Identifier {
+			t.Errorf("Expected the response identifier %v, but got %v", req.Header.Identifier, res.Header.Identifier)
+		}
+	}
+}
+
+// TestServeMuxUnhandled tests that unmatched message types return ErrNoHandler
+func TestServeMuxUnhandled(t *testing.T) {
+	mux := ipcserver.NewServeMux()
+	mux.HandleData(ipc.MSG_MSG, ipc.DATA_JSON, reply("json"))
+
+	req := &ipc.IPCRequest{
+		Header:  ipc.IPCHeader{MessageType: ipc.MSG_MSG},
+		Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT},
+	}
+	_, err := mux.ServeIPC(context.Background(), req)
+	if !errors.Is(err, ipcserver.ErrNoHandler) {
+		t.Errorf("Expected ErrNoHandler, but got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package ipcserver
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// modulesMu guards MODULEIDENTIFIERS and MODULECREDENTIALS, which are read by the connections while modules may still be added
+var modulesMu sync.RWMutex
+
+// ConnInfo describes the module on the other end of a connection, as verified by the handshake
+type ConnInfo struct {
+	Module     string            // Name of the module, as declared in the module definitions
+	Identifier [4]byte           // Identifier of the module
+	Peer       *Credentials      // Credentials of the peer process, nil if they can't be read
+	Cert       *x509.Certificate // Verified certificate of the client over mutual TLS, which names the module, nil otherwise
+}
+
+type connInfoKey struct{}
+
+// ConnInfoFromContext returns the information about the connection the request was received on.
+// It is available in the context passed to the handlers, once the handshake is completed.
+func ConnInfoFromContext(ctx context.Context) (*ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(*ConnInfo)
+	return info, ok
+}
+
+// moduleIdentifier returns the identifier of the named module
+func moduleIdentifier(name string) ([4]byte, bool) {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+
+	var id [4]byte
+	moduleId, ok := MODULEIDENTIFIERS[name]
+	copy(id[:], moduleId)
+	return id, ok
+}
+
+// moduleByIdentifier returns the name of the module with the given identifier
+func moduleByIdentifier(id [4]byte) (string, bool) {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+
+	for name, moduleId := range MODULEIDENTIFIERS {
+		var padded [4]byte
+		copy(padded[:], moduleId)
+		if padded == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// moduleCertName returns the name the client certificate of the module must have, see Module.Cert
+func moduleCertName(name string) string {
+	if def := moduleDefinition(name); def != nil && def.Cert != "" {
+		return def.Cert
+	}
+	return name
+}
+
+// certAllows returns true if the client certificate names the module in its common name or DNS names,
+// or if there is no client certificate. Any certificate of the CA passes the TLS handshake, this binds it to one module.
+func certAllows(cert *x509.Certificate, module string) bool {
+	if cert == nil {
+		return true
+	}
+	want := moduleCertName(module)
+	return cert.Subject.CommonName == want || slices.Contains(cert.DNSNames, want)
+}
+
+// handshake verifies the MSG_CONN message of a client.
+// The client announces its identifier in the header, and the protocol version as DATA_INT in the message.
+// On success the connection is bound to the module, and a MSG_CONNACK response is returned.
+func (cn *connection) handshake(req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	if cn.info != nil {
+		return nil, fmt.Errorf("%w: handshake already completed for module %s", ipc.ErrInvalidRequest, cn.info.Module)
+	}
+
+	version, err := strconv.Atoi(string(req.Message.Data))
+	if err != nil || req.Message.Datatype != ipc.DATA_INT {
+		return nil, fmt.Errorf("%w: invalid protocol version: %q", ipc.ErrDecode, req.Message.StringData)
+	}
+	if version != ipc.PROTOCOL_VERSION {
+		return nil, fmt.Errorf("%w: unsupported protocol version %d, expected %d", ipc.ErrInvalidRequest, version, ipc.PROTOCOL_VERSION)
+	}
+
+	name, ok := moduleByIdentifier(req.Header.Identifier)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ipc.ErrUnknownModule, req.Header.Identifier[:])
+	}
+
+	if allowlist := moduleAllowlist(name); !allowlist.Allows(cn.peer) {
+		if cn.peer == nil {
+			return nil, fmt.Errorf("%w: module %s requires peer credentials, which are not available", ipc.ErrUnauthorized, name)
+		}
+		return nil, fmt.Errorf("%w: module %s is not allowed for uid=%d gid=%d", ipc.ErrUnauthorized, name, cn.peer.UID, cn.peer.GID)
+	}
+	if !certAllows(cn.cert, name) {
+		return nil, fmt.Errorf("%w: the client certificate %q is not the certificate of module %s", ipc.ErrUnauthorized, cn.cert.Subject.CommonName, name)
+	}
+
+	info := &ConnInfo{
+		Module:     name,
+		Identifier: req.Header.Identifier,
+		Peer:       cn.peer,
+		Cert:       cn.cert,
+	}
+	cn.log = cn.log.With("module", name)
+	cn.server.mu.Lock()
+	cn.info = info // Published with the logger, for the readers holding mu
+	cn.server.mu.Unlock()
+	cn.ctx = context.WithValue(cn.ctx, connInfoKey{}, info)
+
+	cn.log.Info("module connected")
+
+	return NewResponse(req, ipc.MSG_CONNACK, ipc.DATA_TEXT, []byte(cn.server.identifier)), nil
+}
+
+// authorize checks that the request is sent on a connection that completed the handshake, by the module it is bound to
+func (cn *connection) authorize(req *ipc.IPCRequest) error {
+	if cn.info == nil {
+		return fmt.Errorf("%w: handshake required, send MSG_CONN before message type 0x%02x", ipc.ErrUnauthorized, req.Header.MessageType)
+	}
+	if req.Header.Identifier != cn.info.Identifier {
+		return fmt.Errorf("%w: identifier %q does not match the connected module %s", ipc.ErrUnauthorized, req.Header.Identifier[:], cn.info.Module)
+	}
+
+	switch req.Header.MessageType {
+	case ipc.MSG_PING, ipc.MSG_PONG, ipc.MSG_CANCEL, ipc.MSG_DISCONNECT:
+		return nil // Control messages are not restricted by the module definition
+	}
+
+	// The definition may have been reloaded since the last request
+	if def := moduleDefinition(cn.info.Module); def != cn.module {
+		cn.module, cn.limiter = def, nil
+		if def != nil {
+			cn.limiter = newLimiter(def.RateLimit)
+		}
+	}
+	if cn.module != nil && !cn.module.allows(req.Header.MessageType) {
+		return fmt.Errorf("%w: module %s may not send message type 0x%02x", ErrForbidden, cn.info.Module, req.Header.MessageType)
+	}
+	if !cn.limiter.allow() {
+		return fmt.Errorf("%w: module %s exceeded its rate limit of %g requests per second", ipc.ErrRateLimited, cn.info.Module, cn.limiter.rate)
+	}
+	return nil
+}
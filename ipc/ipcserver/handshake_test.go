@@ -0,0 +1,170 @@
+package ipcserver_test
+
+import (
+	"context"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// roundTrip sends a request on a raw stream and returns the response
+func roundTrip(t *testing.T, c *ipc.Stream, req ipc.IPCRequest) ipc.IPCRequest {
+	t.Helper()
+
+	req.Checksum32 = int(crc32.ChecksumIEEE(req.Message.Data))
+	if err := c.Send(&req); err != nil {
+		t.Fatalf("Failed to send the request: %v", err)
+	}
+	res, err := c.Receive()
+	if err != nil {
+		t.Fatalf("Failed to read the response: %v", err)
+	}
+	return res
+}
+
+// dial opens a raw stream to the server, without a handshake
+func dial(t *testing.T, path string) *ipc.Stream {
+	t.Helper()
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return ipc.NewStream(c, nil)
+}
+
+// TestHandshake tests that the handshake binds the connection to the module
+func TestHandshake(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			info, ok := ipcserver.ConnInfoFromContext(ctx)
+			if !ok {
+				return nil, context.Canceled
+			}
+			return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte(info.Module)), nil
+		})
+	})
+	c := connect(t, path, "HAND")
+
+	res, err := c.SendIPCMessage(c.CreateReq("who am i", ipc.MSG_MSG, ipc.DATA_TEXT))
+	if err != nil || res.StringData != "module HAND" {
+		t.Errorf("Expected the module name from the handshake, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestHandshakeUnknownModule tests that modules missing from the module definitions are refused
+func TestHandshakeUnknownModule(t *testing.T) {
+	path := startServer(t, nil)
+
+	c := &ipcclient.IPCClient{Name: "unknown", Identifier: [4]byte{'N', 'O', 'P', 'E'}, Sock: path}
+	if err := c.Connect(); err == nil {
+		c.Close()
+		t.Errorf("Expected the handshake to be refused for an unknown module")
+	}
+}
+
+// TestHandshakeRequired tests that messages on a connection without a handshake are rejected
+func TestHandshakeRequired(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+	ipcserver.AddModule("module RAWC", []byte("RAWC"))
+
+	c := dial(t, path)
+
+	header := ipc.IPCHeader{Identifier: [4]byte{'R', 'A', 'W', 'C'}, MessageType: ipc.MSG_MSG}
+	res := roundTrip(t, c, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("hello")}})
+	if res.Header.MessageType != ipc.MSG_ERROR {
+		t.Errorf("Expected MSG_ERROR before the handshake, but got message type 0x%02x", res.Header.MessageType)
+	}
+
+	header.MessageType = ipc.MSG_CONN
+	version := []byte(strconv.Itoa(ipc.PROTOCOL_VERSION))
+	res = roundTrip(t, c, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_INT, Data: version}})
+	if res.Header.MessageType != ipc.MSG_CONNACK {
+		t.Fatalf("Expected MSG_CONNACK, but got message type 0x%02x: %s", res.Header.MessageType, res.Message.StringData)
+	}
+
+	header.MessageType = ipc.MSG_MSG
+	res = roundTrip(t, c, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("hello")}})
+	if res.Header.MessageType != ipc.MSG_MSG || res.Message.StringData != "hello" {
+		t.Errorf("Expected the echo after the handshake, but got message type 0x%02x: %s", res.Header.MessageType, res.Message.StringData)
+	}
+
+	// Another module can't send on the connection
+	header.Identifier = [4]byte{'S', 'P', 'O', 'F'}
+	res = roundTrip(t, c, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("hello")}})
+	if res.Header.MessageType != ipc.MSG_ERROR {
+		t.Errorf("Expected MSG_ERROR for a spoofed identifier, but got message type 0x%02x", res.Header.MessageType)
+	}
+}
+
+// TestHandshakeTimeout tests that a connection without a handshake is closed after ipc.Timeout,
+// and that the connections past the handshake are not
+func TestHandshakeTimeout(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(ipc.Timeout + 5*time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, but got %v", err)
+	}
+
+	c := connect(t, path, "SLOW")
+	time.Sleep(ipc.Timeout + 200*time.Millisecond)
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the connected module to stay connected, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestHandshakeVersion tests that unsupported protocol versions are refused
+func TestHandshakeVersion(t *testing.T) {
+	path := startServer(t, nil)
+	ipcserver.AddModule("module VERS", []byte("VERS"))
+
+	c := dial(t, path)
+
+	header := ipc.IPCHeader{Identifier: [4]byte{'V', 'E', 'R', 'S'}, MessageType: ipc.MSG_CONN}
+	version := []byte(strconv.Itoa(ipc.PROTOCOL_VERSION + 1))
+	res := roundTrip(t, c, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_INT, Data: version}})
+	if res.Header.MessageType != ipc.MSG_ERROR {
+		t.Errorf("Expected MSG_ERROR for an unsupported version, but got message type 0x%02x", res.Header.MessageType)
+	}
+}
+
+// TestResponseMessageId tests that responses carry the message id of the request, flagged as a reply
+func TestResponseMessageId(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+	ipcserver.AddModule("module MSID", []byte("MSID"))
+
+	c := dial(t, path)
+	id := [4]byte{'M', 'S', 'I', 'D'}
+	roundTrip(t, c, ipc.IPCRequest{
+		Header:  ipc.IPCHeader{Identifier: id, MessageType: ipc.MSG_CONN},
+		Message: ipc.IPCMessage{Datatype: ipc.DATA_INT, Data: []byte(strconv.Itoa(ipc.PROTOCOL_VERSION))},
+	})
+
+	res := roundTrip(t, c, ipc.IPCRequest{
+		Header:  ipc.IPCHeader{Identifier: id, MessageType: ipc.MSG_MSG, MessageId: 42},
+		Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("hello"), StringData: "hello"},
+	})
+	if res.Header.MessageId != 42 || res.Header.Flags&ipc.FLAG_REPLY == 0 {
+		t.Errorf("Expected a reply to message 42, but got id %d with flags %#x", res.Header.MessageId, res.Header.Flags)
+	}
+}
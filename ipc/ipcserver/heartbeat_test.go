@@ -0,0 +1,138 @@
+package ipcserver_test
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// eventually retries the condition until it holds, or fails the test after a while
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHeartbeat tests that both sides measure the health of the connection from the heartbeats
+func TestHeartbeat(t *testing.T) {
+	server, path := newServer(t, nil, ipcserver.WithHeartbeat(10*time.Millisecond, 3))
+	c := connect(t, path, "BEAT", ipcclient.WithHeartbeat(10*time.Millisecond, 3))
+
+	eventually(t, "the server to measure the round-trip time", func() bool {
+		health := server.Health()
+		return len(health) == 1 && health[0].RTT > 0
+	})
+	health := server.Health()[0]
+	if health.Module != "module BEAT" || health.Missed != 0 || time.Since(health.LastSeen) > time.Second {
+		t.Errorf("Expected a healthy connection of module BEAT, but got %+v", health)
+	}
+
+	eventually(t, "the client to measure the round-trip time", func() bool {
+		health, err := c.Health()
+		return err == nil && health.RTT > 0 && health.Missed == 0
+	})
+}
+
+// TestHeartbeatDeadClient tests that the server closes the connection of a client that doesn't answer the pings
+func TestHeartbeatDeadClient(t *testing.T) {
+	server, path := newServer(t, nil, ipcserver.WithHeartbeat(10*time.Millisecond, 2))
+	ipcserver.AddModule("module DEAD", []byte("DEAD"))
+
+	c := dial(t, path)
+	res := roundTrip(t, c, ipc.IPCRequest{
+		Header:  ipc.IPCHeader{Identifier: [4]byte{'D', 'E', 'A', 'D'}, MessageType: ipc.MSG_CONN},
+		Message: ipc.IPCMessage{Datatype: ipc.DATA_INT, Data: []byte(strconv.Itoa(ipc.PROTOCOL_VERSION))},
+	})
+	if res.Header.MessageType != ipc.MSG_CONNACK {
+		t.Fatalf("Expected MSG_CONNACK, but got message type 0x%02x", res.Header.MessageType)
+	}
+
+	// Read the pings without answering them, until the server gives up on the connection
+	c.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	pings := 0
+	for {
+		msg, err := c.Receive()
+		if err != nil {
+			break
+		}
+		if msg.Header.MessageType == ipc.MSG_PING {
+			pings++
+		}
+	}
+	if pings < 2 {
+		t.Errorf("Expected the server to ping at least twice before closing the connection, but got %d pings", pings)
+	}
+
+	eventually(t, "the connection to be removed", func() bool {
+		return len(server.Health()) == 0
+	})
+}
+
+// TestHeartbeatDeadServer tests that the client closes the connection to a server that doesn't answer the pings
+func TestHeartbeatDeadServer(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "dead.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	closed := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		stream := ipc.NewStream(conn, nil)
+		defer stream.Close()
+
+		// Accept the handshake, then go silent
+		req, err := stream.Receive()
+		if err != nil {
+			return
+		}
+		stream.Send(ipcserver.NewResponse(&req, ipc.MSG_CONNACK, ipc.DATA_TEXT, []byte("DEAD")))
+
+		pings := 0
+		for {
+			msg, err := stream.Receive()
+			if err != nil {
+				closed <- pings
+				return
+			}
+			if msg.Header.MessageType == ipc.MSG_PING {
+				pings++
+			}
+		}
+	}()
+
+	c := ipcclient.NewIPCClient("client LIVE", "LIVE", "dead", ipcclient.WithSocketPath(ln.Addr().String()), ipcclient.WithHeartbeat(10*time.Millisecond, 2))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case pings := <-closed:
+		if pings < 2 {
+			t.Errorf("Expected the client to ping at least twice before closing the connection, but got %d pings", pings)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the client to close the connection")
+	}
+
+	if err := c.Publish("after.close", ipc.DATA_TEXT, nil); err == nil {
+		t.Errorf("Expected requests to fail after the connection was closed")
+	}
+}
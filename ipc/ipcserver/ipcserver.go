@@ -1,23 +1,23 @@
 package ipcserver
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/gob"
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"hash/crc32"
-	"io"
+	"log/slog"
 	"net"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/pynezz/pynezzentials"
-	"github.com/pynezz/pynezzentials/ansi"
 	"github.com/pynezz/pynezzentials/fsutil"
 	"github.com/pynezz/pynezzentials/ipc"
-	"gopkg.in/yaml.v3"
 )
 
 /* CONSTANTS
@@ -46,143 +46,493 @@ type IPCServer struct {
 	path       string
 	identifier string
 	conn       net.Listener
-}
 
-func init() {
-	MODULEIDENTIFIERS = map[string][]byte{}
+	addr      string      // TCP address, the server listens on the UNIX domain socket at path if empty
+	tlsConfig *tls.Config // TLS configuration of the TCP listener, plain TCP if nil
+
+	mux        *ServeMux    // Default router, used unless a handler is set with SetHandler
+	handler    Handler      // Handler for incoming requests
+	middleware []Middleware // Middleware around the handler, the first is the outermost
+	chain      Handler      // The handler wrapped in the middleware, built by Serve
+	codec      ipc.Codec    // Wire format of the connections
+
+	maxConns    int       // Limit of connections served at the same time
+	maxInflight int       // Limit of requests handled at the same time
+	connSlots   semaphore // Acquired for every served connection
+	inflight    semaphore // Acquired for every request being handled
+
+	heartbeat time.Duration // Interval of the pings sent to the clients, 0 disables them
+	maxMissed int           // Pongs a client may miss in a row before its connection is closed
+	pingId    atomic.Uint64 // Last message id used for a ping
+
+	topics *topics // Subscriptions of the connections
+
+	socketMode os.FileMode // Mode of the socket file, DefaultSocketMode if 0
+	lockFile   *os.File    // Lock file next to the socket, held while the server runs, guarded by mu
+
+	dgramPath   string          // Path of the datagram socket, datagram mode is disabled if empty
+	dgram       *datagramSocket // Datagram socket, while the server runs, guarded by mu
+	socketGroup string          // Group of the socket file, by name or id, if set
+
+	jsonrpcPath string         // Path of the JSON-RPC socket, JSON-RPC compatibility mode is disabled if empty
+	jsonrpc     *jsonrpcSocket // JSON-RPC socket, while the server runs, guarded by mu
+
+	modules  *moduleFile // Module definitions file, reloaded on SIGHUP and when it changes
+	reloadMu sync.Mutex  // Serializes the reloads of the module definitions file
+
+	log         *slog.Logger // Logger of the server, the default logger of the ipc packages if nil
+	logPayloads bool         // Whether the payloads of the messages are logged
+	redact      ipc.Redactor // What to log of the payloads
+
+	mu         sync.Mutex
+	conns      map[*connection]struct{} // Connected clients
+	connWg     sync.WaitGroup           // Connections being served
+	inShutdown atomic.Bool              // Set when the server is shutting down
 }
 
-func LoadModules(path string) {
-	if !fsutil.FileExists(path) {
-		ansi.PrintError("LoadModules(): File does not exist: " + path)
-	}
-	ansi.PrintSuccess("File exists: " + path)
-	f, err := os.Open(path)
-	if err != nil {
-		ansi.PrintError("LoadModules(): " + err.Error())
-		return
-	}
-	defer f.Close()
+// ErrServerClosed is returned by Serve and Listen after the server has been shut down
+var ErrServerClosed = errors.New("ipcserver: server closed")
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fmt.Println(line)
-		parts := strings.Split(line, " ")
-		if len(parts[0]) < 1 {
-			// empty line
-			continue
-		}
-		firstChar := parts[0][0]
-		if firstChar == '#' || firstChar == ' ' || firstChar == '\t' || firstChar == '/' || firstChar == '*' || firstChar == '\n' || firstChar == '\r' {
-			// comment
-			continue
-		}
+// DefaultShutdownTimeout is how long Serve and Cleanup wait for in-flight requests when shutting down
+const DefaultShutdownTimeout = 5 * time.Second
 
-		for i, part := range parts {
-			if part == "\t" || part == " " {
-				// skip
-				continue
-			}
-			parts[i] = strings.TrimSpace(part)
-		}
+// servers keeps track of the running servers, for Cleanup
+var servers = struct {
+	sync.Mutex
+	m map[*IPCServer]struct{}
+}{m: map[*IPCServer]struct{}{}}
+
+// semaphore limits concurrency. A nil semaphore never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
 
-		AddModule(parts[0], []byte(parts[1])) // Add module to the server
-		ansi.PrintColorf(ansi.LightCyan, "Loaded module: %s", parts[0])
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
 	}
 }
 
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+func init() {
+	MODULEIDENTIFIERS = map[string][]byte{}
+}
+
 // NewIPCServer creates a new IPC server and returns it.
-func NewIPCServer(name string, identifier string) *IPCServer {
+func NewIPCServer(name string, identifier string, opts ...Option) *IPCServer {
 	path := ipc.DefaultSock(name)
 	IPCID = []byte(identifier)
 	ipc.SetIPCID(IPCID)
 	SetServerIdentifier(IPCID)
 
-	ansi.PrintColorf(ansi.LightCyan, "[🔌SOCKETS] IPC server path: %s", path)
+	mux := NewServeMux()
+
+	s := &IPCServer{
+		path:        path,
+		identifier:  identifier,
+		conn:        nil,
+		mux:         mux,
+		handler:     mux,
+		maxConns:    DefaultMaxConnections,
+		maxInflight: DefaultMaxInflight,
+		maxMissed:   DefaultHeartbeatMissed,
+		middleware:  DefaultMiddleware(),
+		conns:       map[*connection]struct{}{},
+		topics:      newTopics(),
+		codec:       ipc.GobCodec,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.connSlots = newSemaphore(s.maxConns)
+	s.inflight = newSemaphore(s.maxInflight)
+
+	return s
+}
 
-	return &IPCServer{
-		path:       path,
-		identifier: identifier,
-		conn:       nil,
+// Handle registers the handler for the given message type on the server's router
+func (s *IPCServer) Handle(msgType byte, h Handler) {
+	s.mux.Handle(msgType, h)
+}
+
+// HandleFunc registers the handler function for the given message type on the server's router
+func (s *IPCServer) HandleFunc(msgType byte, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	s.mux.HandleFunc(msgType, f)
+}
+
+// HandleData registers the handler for the given message type and datatype on the server's router
+func (s *IPCServer) HandleData(msgType byte, dataType ipc.DataType, h Handler) {
+	s.mux.HandleData(msgType, dataType, h)
+}
+
+// HandleDataFunc registers the handler function for the given message type and datatype on the server's router
+func (s *IPCServer) HandleDataFunc(msgType byte, dataType ipc.DataType, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	s.mux.HandleDataFunc(msgType, dataType, f)
+}
+
+// SetHandler replaces the server's router with the given handler.
+// Passing nil restores the default router.
+func (s *IPCServer) SetHandler(h Handler) {
+	if h == nil {
+		h = s.mux
 	}
+	s.handler = h
 }
 
-// Add a new module identifier to the map
+// Add a new module identifier to the map. Identifiers over 4 bytes are refused, like in module definitions.
 func AddModule(identifier string, id []byte) {
-	if len(id) > 4 {
-		ansi.PrintError("AddModule(): Identifier length must be 4 bytes")
-		ansi.PrintInfo("Truncating the identifier to 4 bytes")
-		id = id[:4]
+	if err := checkIdentifier(identifier, id); err != nil {
+		ipc.Logger().Error("AddModule(): refusing module", "module", identifier, "err", err)
+		return
 	}
+	modulesMu.Lock()
 	MODULEIDENTIFIERS[identifier] = id
+	modulesMu.Unlock()
 
-	ansi.PrintSuccess("added module:" + string(MODULEIDENTIFIERS[identifier]))
+	ipc.Logger().Debug("added module", "module", identifier, "identifier", string(id))
 }
 
 // Set the server identifier to the SERVERIDENTIFIER variable
 func SetServerIdentifier(id []byte) {
 	if len(id) > 4 {
-		ansi.PrintError("SetServerIdentifier(): Identifier length must be 4 bytes")
-		ansi.PrintInfo("Truncating the identifier to 4 bytes")
+		ipc.Logger().Warn("SetServerIdentifier(): identifier length must be 4 bytes, truncating it")
 		id = id[:4]
 	}
 	SERVERIDENTIFIER = [4]byte(id) // Convert the slice to an array
-	ansi.PrintSuccess("Set server identifier: " + string(SERVERIDENTIFIER[:]))
 }
 
-// Write a socket file and add it to the map
-func (s *IPCServer) InitServerSocket() bool {
-	// Making sure the socket is clean before starting
-	if err := os.RemoveAll(s.path); err != nil {
-		ansi.PrintError("InitServerSocket(): Failed to remove old socket: " + err.Error())
-		return false
+// InitServerSocket makes sure the socket path is free before starting: it takes the lock file next to the socket,
+// and removes the socket left by a server that is no longer running.
+// If another server holds the lock, or answers on the socket, ErrServerRunning is returned and the socket is left alone.
+//
+// Serve calls it before listening. The lock is held until the server is shut down.
+func (s *IPCServer) InitServerSocket() error {
+	if s.tcp() || ipc.IsAbstractSock(s.path) {
+		return nil // Abstract sockets go away with the listener, and can't be bound twice
 	}
+	return s.lock()
+}
 
-	return true
+// Listen creates a new listener on the socket path, or the TCP address, and serves it until the server is shut down.
+// It is the same as calling Serve with context.Background().
+func (s *IPCServer) Listen() error {
+	return s.Serve(context.Background())
 }
 
-// Creates a new listener on the socket path (which should be set in the config in the future)
-func (s *IPCServer) Listen() {
-	ansi.PrintColorBold(ansi.DarkGreen, "🎉 IPC server running!")
-	s.conn, _ = net.Listen(AF_UNIX, s.path)
-	ansi.PrintColorf(ansi.LightCyan, "[🔌SOCKETS] Starting listener on %s", s.path)
+// Serve creates a new listener on the socket path, or the TCP address (see WithTCP), and serves the connections to it.
+//
+// When the context is cancelled, the server is shut down gracefully, waiting up to DefaultShutdownTimeout for in-flight requests.
+// Serve always returns a non-nil error. After Shutdown or a cancelled context, the error is ErrServerClosed.
+func (s *IPCServer) Serve(ctx context.Context) error {
+	if s.modules != nil {
+		if err := s.ReloadModules(); err != nil {
+			return err
+		}
 
-	for {
-		ansi.PrintDebug("Waiting for connection...")
-		conn, err := s.conn.Accept()
-		ansi.PrintColorf(ansi.LightCyan, "[🔌SOCKETS]: New connection from %s", conn.LocalAddr().String())
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP) // Before listening, so a SIGHUP never kills a running server
+		defer signal.Stop(hup)
+
+		wctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.watchModules(wctx, hup)
+	}
+
+	ln, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("ipcserver: listen on %s: %w", s.address(), err)
+	}
 
+	var dgram *datagramSocket
+	if s.dgramPath != "" {
+		if dgram, err = s.listenDatagram(); err != nil {
+			ln.Close()
+			s.unlock()
+			return fmt.Errorf("ipcserver: listen on %s: %w", s.dgramPath, err)
+		}
+	}
+
+	var jsonrpc *jsonrpcSocket
+	if s.jsonrpcPath != "" {
+		if jsonrpc, err = s.listenJSONRPC(); err != nil {
+			ln.Close()
+			if dgram != nil {
+				dgram.close()
+			}
+			s.unlock()
+			return fmt.Errorf("ipcserver: listen on %s: %w", s.jsonrpcPath, err)
+		}
+	}
+
+	s.mu.Lock()
+	if s.shuttingDown() {
+		s.mu.Unlock()
+		ln.Close()
+		if dgram != nil {
+			dgram.close()
+		}
+		if jsonrpc != nil {
+			jsonrpc.close()
+		}
+		s.unlock()
+		return ErrServerClosed
+	}
+	s.conn = ln
+	s.dgram = dgram
+	s.jsonrpc = jsonrpc
+	s.chain = Chain(HandlerFunc(s.dispatch), s.middleware...)
+	if dgram != nil {
+		s.connWg.Add(1)
+		go s.serveDatagrams(dgram)
+	}
+	if jsonrpc != nil {
+		s.connWg.Add(1)
+		go s.acceptJSONRPC(jsonrpc)
+	}
+	s.mu.Unlock()
+
+	servers.Lock()
+	servers.m[s] = struct{}{}
+	servers.Unlock()
+
+	log := s.logger().With("codec", s.codec.Name())
+	if s.tcp() {
+		log = log.With("addr", ln.Addr().String(), "tls", s.tlsConfig != nil)
+	} else {
+		log = log.With("path", s.path)
+	}
+	if dgram != nil {
+		log = log.With("datagram_path", dgram.path)
+	}
+	if jsonrpc != nil {
+		log = log.With("jsonrpc_path", jsonrpc.path)
+	}
+	log.Info("IPC server running")
+
+	// Shut down when the context is cancelled
+	shutdownErr := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		sctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		shutdownErr <- s.Shutdown(sctx)
+	})
+	defer stop()
+
+	for {
+		s.connSlots.acquire() // Wait for a free slot before accepting
+		conn, err := ln.Accept()
 		if err != nil {
-			ansi.PrintError("Listen(): " + err.Error())
-			continue
+			s.connSlots.release()
+			if s.shuttingDown() {
+				break
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return fmt.Errorf("ipcserver: accept: %w", err)
+		}
+		s.logger().Debug("new connection", "addr", conn.LocalAddr().String())
+
+		cn, ok := s.track(conn)
+		if !ok {
+			s.connSlots.release()
+			conn.Close()
+			break
+		}
+
+		// Every connection is served on its own goroutine, so one module can't block the others
+		go func() {
+			defer s.connSlots.release()
+			defer s.untrack(cn)
+			cn.serve()
+		}()
+	}
+
+	if !stop() { // The context triggered the shutdown
+		if err := <-shutdownErr; err != nil {
+			return err
+		}
+	}
+	return ErrServerClosed
+}
+
+// track adds the connection to the connected clients. It returns false if the server is shutting down.
+func (s *IPCServer) track(c net.Conn) (*connection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return nil, false
+	}
+	cn := s.newConnection(c)
+	s.conns[cn] = struct{}{}
+	s.connWg.Add(1)
+	return cn, true
+}
+
+func (s *IPCServer) untrack(cn *connection) {
+	s.mu.Lock()
+	delete(s.conns, cn)
+	s.mu.Unlock()
+	s.connWg.Done()
+}
+
+// id returns the identifier of the server, as used in the message headers
+func (s *IPCServer) id() [4]byte {
+	var id [4]byte
+	copy(id[:], s.identifier)
+	return id
+}
+
+// ConnHealth is the health of the connection of a module
+type ConnHealth struct {
+	ConnInfo
+	ipc.Health
+}
+
+// Health returns the health of the connected modules.
+// The last seen time and the missed pongs can be used to alert when a module hangs without closing its socket.
+// The round-trip time is only measured with heartbeats enabled, see WithHeartbeat.
+func (s *IPCServer) Health() []ConnHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := make([]ConnHealth, 0, len(s.conns))
+	for cn := range s.conns {
+		if cn.info == nil {
+			continue // Not a module yet
 		}
+		health = append(health, ConnHealth{ConnInfo: *cn.info, Health: cn.hb.Health()})
+	}
+	return health
+}
 
-		s.handleConnection(conn)
+func (s *IPCServer) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// Shutdown gracefully shuts down the server.
+//
+// It stops accepting connections and reading new requests, then waits for the in-flight requests to finish.
+// The connected clients are sent a MSG_DISCONNECT message before their connection is closed.
+// Finally the socket file is removed.
+//
+// If the context expires before the requests finish, the remaining connections are closed,
+// their requests are cancelled, and the context's error is returned.
+func (s *IPCServer) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	s.mu.Lock()
+	s.inShutdown.Store(true)
+	if s.conn != nil {
+		if err := s.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	if s.dgram != nil {
+		s.dgram.conn.SetReadDeadline(time.Now()) // Stop receiving datagrams
+	}
+	if s.jsonrpc != nil {
+		if err := s.jsonrpc.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	for cn := range s.conns {
+		cn.c.SetReadDeadline(time.Now()) // Stop reading new requests
 	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		for cn := range s.conns {
+			cn.close()
+		}
+		if s.dgram != nil {
+			s.dgram.cancel()
+		}
+		s.mu.Unlock()
+		errs = append(errs, ctx.Err())
+	}
+
+	// The sockets are only removed by the server that holds them: a server refused with ErrServerRunning
+	// has no lock, and its path is the socket of the running server. The datagram and JSON-RPC sockets
+	// are only set once they are listened on.
+	s.mu.Lock()
+	if s.lockFile != nil && !s.tcp() && !ipc.IsAbstractSock(s.path) {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if s.dgram != nil {
+		if err := s.dgram.close(); err != nil {
+			errs = append(errs, err)
+		}
+		s.dgram.cancel()
+		s.dgram = nil
+	}
+	if s.jsonrpc != nil {
+		if err := s.jsonrpc.close(); err != nil {
+			errs = append(errs, err)
+		}
+		s.jsonrpc = nil
+	}
+	s.mu.Unlock()
+	s.unlock()
+
+	servers.Lock()
+	delete(servers.m, s)
+	servers.Unlock()
+
+	return errors.Join(errs...)
 }
 
 func NewIPCID(identifier string, id []byte) {
 	if len(id) > 4 {
-		ansi.PrintError("NewIPCID(): Identifier length must be 4 bytes")
-		ansi.PrintInfo("Truncating the identifier to 4 bytes")
+		ipc.Logger().Warn("NewIPCID(): identifier length must be 4 bytes, truncating it")
 		id = id[:4]
 	}
 	ipc.SetIPCID(id)
 }
 
-func Cleanup() {
-	// for _, server := range connections.sockets {
-	// 	ansi.PrintItalic("Cleaning up IPC server: " + server.path)
-	// 	err := os.Remove(server.path)
-	// 	if err != nil {
-	// 		ansi.PrintError("Cleanup(): " + err.Error())
-	// 	}
+// Cleanup shuts down all the running servers, waiting up to DefaultShutdownTimeout for in-flight requests
+func Cleanup() error {
+	servers.Lock()
+	running := make([]*IPCServer, 0, len(servers.m))
+	for server := range servers.m {
+		running = append(running, server)
+	}
+	servers.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
 
-	// 	ansi.PrintItalic("Closing connection: " + server.conn.Addr().String())
-	// 	server.conn.Close()
-	// }
-	ansi.PrintItalic("\t... IPC server cleanup complete.")
+	var errs []error
+	for _, server := range running {
+		server.logger().Info("cleaning up IPC server", "addr", server.address())
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server.address(), err))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func crc(b []byte) uint32 {
@@ -191,14 +541,16 @@ func crc(b []byte) uint32 {
 
 // Function to create a new IPCMessage based on the identifier key
 func NewIPCMessage(identifierKey string, messageType byte, data []byte) (*ipc.IPCRequest, error) {
+	modulesMu.RLock()
 	identifier, ok := MODULEIDENTIFIERS[identifierKey]
+	modulesMu.RUnlock()
 	if !ok {
 		AddModule(identifierKey, []byte(identifierKey))
 		return nil, fmt.Errorf("invalid identifier key: %s", identifierKey)
 	}
 
 	var id [4]byte
-	copy(id[:], identifier[:4]) // Ensure no out of bounds panic
+	copy(id[:], identifier) // Shorter identifiers are padded with zeros
 
 	crcsum32 := crc(data)
 
@@ -219,180 +571,40 @@ func NewIPCMessage(identifierKey string, messageType byte, data []byte) (*ipc.IP
 }
 
 // Return the parsed IPCRequest object
-func parseConnection(c net.Conn) (ipc.IPCRequest, error) {
-	var request ipc.IPCRequest
-	// var reqBuffer bytes.Buffer
-
-	ansi.PrintDebug("Trying to decode the bytes to a request struct...")
-	ansi.PrintColorf(ansi.LightCyan, "Decoding the bytes to a request struct... %v", c)
-
-	decoder := gob.NewDecoder(c)
-	err := decoder.Decode(&request)
-	if err != nil {
-		ansi.PrintWarning("parseConnection: Error decoding the request: \n > " + err.Error())
-		return request, err
-	}
-	d := parseData(&request.Message)
-	if d == nil {
-		fmt.Println("Data is nil")
-		return request, nil
-	}
-	if parseMetadata(d) {
-		fmt.Println("Method: ", parseVerb(d))
-	}
-
-	fmt.Println(request.Stringify())
-	ansi.PrintDebug("--------------------")
-	ansi.PrintSuccess("[ipcserver.go] Parsed the message signature!")
-	fmt.Printf("Message ID: %s\n", string(request.MessageSignature))
-
-	return request, nil
+func parseConnection(stream *ipc.Stream) (ipc.IPCRequest, error) {
+	return stream.Receive()
 }
 
-func parseMetadata(msg ipc.GenericData) bool {
-
-	// TODO: Might be reasonable to implement the Metadata struct here (ipc.Metadata)
-	metadata := msg["metadata"]
-	if metadata == nil {
-		return false
-	}
-	source := metadata.(map[string]interface{})["source"]
-	destination := metadata.(map[string]interface{})["destination"].(map[string]interface{})["destination"]
-	destinationId := destination.(map[string]interface{})["id"]
-	destinationName := destination.(map[string]interface{})["name"]
-	destinationInfo := destination.(map[string]interface{})["info"]
-
-	method := metadata.(map[string]interface{})["method"]
-
-	v := ""
-	if method == "POST" {
-		v = "send data to"
-	} else if method == "GET" {
-		v = "get data from"
-	} else if method == "PUT" {
-		v = "update data in"
-	} else if method == "DELETE" {
-		v = "delete data from"
-	} else {
-		v = "???"
-	}
-
-	sentence := fmt.Sprintf("\n %s wants to %s %s with id %s \n", source, v, destinationName, destinationId)
-	ansi.PrintBold(sentence)
-	ansi.PrintItalic("Additional info: " + destinationInfo.(string))
-	return metadata != nil
-}
-
-// Parse the method/verb from the message
-func parseVerb(msg ipc.GenericData) string {
-	v := msg["metadata"].(map[string]interface{})["method"].(string)
-	if v == "" {
-		return "nil"
-	}
-	ansi.PrintSuccess("Verb: " + v)
-	return v
+// Calculate the response time
+func responseTime(reqTime int64) time.Duration {
+	currTime := pynezzentials.UnixNanoTimestamp()
+	return time.Duration(currTime - reqTime)
 }
 
-func parseData(msg *ipc.IPCMessage) ipc.GenericData {
-	var data ipc.GenericData
-
-	switch msg.Datatype {
-	case ipc.DATA_TEXT:
-		fmt.Println("Data is string")
-	case ipc.DATA_INT:
-		// Parse the integer data
-		// Might be a disconnect message
-		fmt.Println("Data is integer")
-
-	case ipc.DATA_JSON:
-		// Parse the JSON data
-		fmt.Println("Data is json / generic data")
-
-		// var temp interface{}
-		err := json.Unmarshal(msg.Data, &data)
-		if err != nil {
-			fmt.Println("Error unmarshaling JSON data:", err)
-		} else {
-			fmt.Printf("Data: %v\n", data)
-		}
-
-		handleGenericData(data)
-
-	case ipc.DATA_YAML:
-		// Parse the YAML data
-		fmt.Println("Data is YAML / generic data")
-		err := yaml.Unmarshal(msg.Data, &data)
-		if err != nil {
-			fmt.Println("Error unmarshaling YAML data:", err)
-		}
-
-		handleGenericData(data)
-
-	case ipc.DATA_BIN:
-		// Parse the binary data
-		fmt.Println("Data is binary / generic data")
-
-		handleGenericData(data)
-
-	default:
-		// Default to generic data
-		fmt.Println("Data is generic")
-		gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(&data)
-		handleGenericData(data)
-
+// serve passes the request through the middleware to the handler, and returns the response for the client
+func (s *IPCServer) serve(ctx context.Context, req *ipc.IPCRequest) *ipc.IPCRequest {
+	response, err := s.chain.ServeIPC(ctx, req)
+	if err != nil {
+		loggerFrom(ctx).Debug("handler failed", ipc.MessageAttr(req), "err", err)
+		return NewErrorResponse(req, err)
 	}
-
-	if data == nil {
-		fmt.Println("Data is nil")
+	if response == nil {
+		response = NewResponse(req, ipc.MSG_ACK, ipc.DATA_TEXT, []byte("OK"))
 	}
 
-	return data
-}
-
-func handleGenericData(data ipc.GenericData) {
-	// Handle the data
-}
-
-// Calculate the response time
-func responseTime(reqTime int64) {
-	currTime := pynezzentials.UnixNanoTimestamp()
-	diff := currTime - reqTime
-	fmt.Printf("Response time: %d\n", diff)
-	fmt.Printf("Seconds: %f\n", float64(diff)/1e9)
-	fmt.Printf("Milliseconds: %f\n", float64(diff)/1e6)
-	fmt.Printf("Microseconds: %f\n", float64(diff)/1e3)
+	return response
 }
 
-// handleConnection handles the incoming connection
-func (s *IPCServer) handleConnection(c net.Conn) {
-	defer c.Close()
-
-	ansi.PrintColorf(ansi.LightCyan, "[🔌SOCKETS] Handling connection...")
-
-	for {
-		request, err := parseConnection(c)
-		if err != nil {
-			if err == io.EOF {
-				ansi.PrintDebug("Connection closed by client")
-				break
-			}
-			ansi.PrintError("Error parsing request: " + err.Error())
-			break
-		}
-
-		ansi.PrintDebug("Request parsed: " + strconv.Itoa(request.Checksum32))
-
-		// Process the request...
-		ansi.PrintColorf(ansi.BgGreen, "Received: %+v\n", request)
-
-		// Finally, respond to the client
-		err = s.respond(c, request)
-		if err != nil {
-			ansi.PrintError("handleConnection: " + err.Error())
-			break
+// dispatch is the innermost handler of the middleware chain.
+// The publish/subscribe messages are handled by the server itself, the other messages by the handler.
+func (s *IPCServer) dispatch(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	switch req.Header.MessageType {
+	case ipc.MSG_SUBSCRIBE, ipc.MSG_UNSUBSCRIBE, ipc.MSG_PUBLISH:
+		if cn, ok := ctx.Value(connKey{}).(*connection); ok {
+			return cn.pubsub(req)
 		}
 	}
-
+	return s.handler.ServeIPC(ctx, req)
 }
 
 type ReturnData struct {
@@ -413,43 +625,17 @@ func (d *ReturnData) GetLogs(path string, filter string) {
 	// Not sure if this fits here. Might rather implement it in the internal package
 }
 
-// c is the connection to the client
-// req is the request from the client
-func (s *IPCServer) respond(c net.Conn, req ipc.IPCRequest) error {
-	if req.Checksum32 == 0 {
-		ansi.PrintWarning("Checksum is 0, skipping response")
-		return nil
-	}
-
-	ansi.PrintDebug("Responding to the client...")
-	moduleId := string(req.Header.Identifier[:])
-	var response *ipc.IPCRequest
-	var err error
-	if req.Checksum32 == int(crc(req.Message.Data)) {
-		// TODO: Refactor. Not very pretty. (the identifier key part)
-		response, err = NewIPCMessage(moduleId, ipc.MSG_ACK, []byte("OK"))
-	} else {
-		fmt.Printf("Request checksum: %v\nCalculated checksum: %v\n", req.Checksum32, crc(req.Message.Data))
-		response, err = NewIPCMessage(moduleId, ipc.MSG_ERROR, []byte("CHKSUM ERROR"))
-	}
-	if err != nil {
-		return err
-	}
-
-	var responseBuffer bytes.Buffer
-	encoder := gob.NewEncoder(&responseBuffer)
-	err = encoder.Encode(response)
-	if err != nil {
-		return err
+// stream is the connection to the client
+// response is the response from the handler
+func (s *IPCServer) respond(stream *ipc.Stream, response *ipc.IPCRequest) error {
+	if response.MessageSignature == nil {
+		response.MessageSignature = []byte(s.identifier)
 	}
 
-	_, err = c.Write(responseBuffer.Bytes())
-	if err != nil {
+	if err := stream.Send(response); err != nil {
 		return err
 	}
-	ansi.PrintColor(ansi.BgGreen, "🚀 Response sent!")
-
-	responseTime(req.Timestamp)
+	s.logMessage(s.logger(), "response sent", response)
 
 	return nil
 }
@@ -0,0 +1,345 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// startServer starts a server on a temporary socket and returns the socket path.
+// The server is shut down when the test finishes.
+func startServer(t *testing.T, register func(s *ipcserver.IPCServer), opts ...ipcserver.Option) string {
+	t.Helper()
+	_, path := newServer(t, register, opts...)
+	return path
+}
+
+// newServer is like startServer, but also returns the server and doesn't take a test cleanup for granted
+func newServer(t *testing.T, register func(s *ipcserver.IPCServer), opts ...ipcserver.Option) (*ipcserver.IPCServer, string) {
+	t.Helper()
+	return serveAt(t, filepath.Join(t.TempDir(), "test.sock"), register, opts...)
+}
+
+// serveAt is like newServer, with the socket at the given path
+func serveAt(t *testing.T, path string, register func(s *ipcserver.IPCServer), opts ...ipcserver.Option) (*ipcserver.IPCServer, string) {
+	t.Helper()
+
+	opts = append([]ipcserver.Option{ipcserver.WithSocketPath(path)}, opts...)
+	server := ipcserver.NewIPCServer("test", "TEST", opts...)
+	if register != nil {
+		register(server)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; !errors.Is(err, ipcserver.ErrServerClosed) {
+			t.Errorf("Expected ErrServerClosed from Serve, but got %v", err)
+		}
+	})
+
+	waitForSocket(t, path)
+	return server, path
+}
+
+// waitForSocket waits until a server accepts connections on the socket
+func waitForSocket(t *testing.T, path string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Server did not start listening on %s", path)
+}
+
+// connect registers the module and connects a new client for it to the socket
+func connect(t *testing.T, path string, identifier string, opts ...ipcclient.Option) *ipcclient.IPCClient {
+	t.Helper()
+
+	ipcserver.AddModule("module "+identifier, []byte(identifier))
+	opts = append([]ipcclient.Option{ipcclient.WithSocketPath(path)}, opts...)
+	c := ipcclient.NewIPCClient("client "+identifier, identifier, "test", opts...)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func echo(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, req.Message.Data), nil
+}
+
+// TestConcurrentClients tests that clients are served at the same time.
+// Every handler waits until all the clients have a request in flight, which only happens if the connections are served concurrently.
+func TestConcurrentClients(t *testing.T) {
+	const clients = 16
+	const messages = 20
+
+	var arrived atomic.Int32
+	all := make(chan struct{})
+
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			if req.Message.StringData == "barrier" {
+				if arrived.Add(1) == clients {
+					close(all)
+				}
+				select {
+				case <-all:
+				case <-time.After(5 * time.Second):
+					return nil, fmt.Errorf("timed out waiting for the other clients")
+				}
+			}
+			return echo(ctx, req)
+		})
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients*messages)
+	for i := 0; i < clients; i++ {
+		c := connect(t, path, fmt.Sprintf("C%03d", i))
+		wg.Add(1)
+		go func(i int, c *ipcclient.IPCClient) {
+			defer wg.Done()
+
+			res, err := c.SendIPCMessage(c.CreateReq("barrier", ipc.MSG_MSG, ipc.DATA_TEXT))
+			if err != nil || res.StringData != "barrier" {
+				errs <- fmt.Errorf("client %d: barrier: got %q, %v", i, res.StringData, err)
+				return
+			}
+
+			for j := 0; j < messages; j++ {
+				msg := fmt.Sprintf("client %d message %d", i, j)
+				res, err := c.SendIPCMessage(c.CreateReq(msg, ipc.MSG_MSG, ipc.DATA_TEXT))
+				if err != nil || res.StringData != msg {
+					errs <- fmt.Errorf("client %d: expected %q, but got %q, %v", i, msg, res.StringData, err)
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestMaxInflight tests that the server doesn't handle more requests at the same time than the configured limit
+func TestMaxInflight(t *testing.T) {
+	const limit = 2
+	const clients = 8
+
+	var current, peak atomic.Int32
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			n := current.Add(1)
+			defer current.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return echo(ctx, req)
+		})
+	}, ipcserver.WithMaxInflight(limit))
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		c := connect(t, path, fmt.Sprintf("C%03d", i))
+		wg.Add(1)
+		go func(c *ipcclient.IPCClient) {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				if _, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > limit {
+		t.Errorf("Expected at most %d requests in flight, but got %d", limit, p)
+	}
+}
+
+// TestUnhandledMessageType tests that message types without a handler get a MSG_ERROR response
+func TestUnhandledMessageType(t *testing.T) {
+	path := startServer(t, nil)
+	c := connect(t, path, "UNHD")
+
+	var res ipc.IPCResponse
+	c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT), func() (ipc.IPCMessage, error) {
+		res = c.ClientListen()
+		return res.Request.Message, nil
+	})
+	if res.Request.Header.MessageType != ipc.MSG_ERROR {
+		t.Errorf("Expected a MSG_ERROR response, but got message type 0x%02x: %q", res.Request.Header.MessageType, res.Message)
+	}
+}
+
+// TestShutdown tests that Shutdown lets in-flight requests finish, disconnects the clients and removes the socket file
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	server, path := newServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			return echo(ctx, req)
+		})
+	})
+	c := connect(t, path, "SHUT")
+	idle := connect(t, path, "IDLE")
+
+	type result struct {
+		res ipc.IPCMessage
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := c.SendIPCMessage(c.CreateReq("slow", ipc.MSG_MSG, ipc.DATA_TEXT))
+		done <- result{res, err}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Unexpected error from Shutdown: %v", err)
+	}
+
+	r := <-done
+	if r.err != nil || r.res.StringData != "slow" {
+		t.Errorf("Expected the in-flight request to finish, but got %q, %v", r.res.StringData, r.err)
+	}
+
+	if res := idle.ClientListen(); res.Request.Header.MessageType != ipc.MSG_DISCONNECT {
+		t.Errorf("Expected a MSG_DISCONNECT message, but got message type 0x%02x", res.Request.Header.MessageType)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket file to be removed, but got %v", err)
+	}
+	if _, err := net.Dial("unix", path); err == nil {
+		t.Errorf("Expected the server to stop accepting connections")
+	}
+}
+
+// TestShutdownDeadline tests that Shutdown cancels the requests that don't finish before the deadline
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server, path := newServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		})
+	})
+	c := connect(t, path, "STUK")
+
+	go c.SendIPCMessage(c.CreateReq("stuck", ipc.MSG_MSG, ipc.DATA_TEXT))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from Shutdown, but got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the request context to be cancelled")
+	}
+}
+
+// TestServeListenError tests that Serve returns the error from the listener
+func TestServeListenError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(file, "test.sock")
+	server := ipcserver.NewIPCServer("test", "TEST", ipcserver.WithSocketPath(path))
+	if err := server.Serve(context.Background()); err == nil || errors.Is(err, ipcserver.ErrServerClosed) {
+		t.Errorf("Expected a listen error, but got %v", err)
+	}
+}
+
+// TestFrameCodec tests a server and client using the binary frame codec
+func TestFrameCodec(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	}, ipcserver.WithCodec(ipc.FrameCodec))
+	c := connect(t, path, "FRAM", ipcclient.WithCodec(ipc.FrameCodec))
+
+	for i := 0; i < 10; i++ {
+		msg := fmt.Sprintf("framed message %d", i)
+		res, err := c.SendIPCMessage(c.CreateReq(msg, ipc.MSG_MSG, ipc.DATA_TEXT))
+		if err != nil || res.StringData != msg {
+			t.Errorf("Expected %q, but got %q, %v", msg, res.StringData, err)
+		}
+	}
+}
+
+// TestMultiplexedClient tests that many goroutines can share one client.
+// The handler answers out of order, so every reply must be routed by its message id.
+func TestMultiplexedClient(t *testing.T) {
+	const goroutines = 32
+	const messages = 10
+
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			time.Sleep(time.Duration(len(req.Message.Data)%7) * time.Millisecond)
+			return echo(ctx, req)
+		})
+	})
+	c := connect(t, path, "MUXC")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*messages)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				text := fmt.Sprintf("goroutine %d message %d%s", i, j, strings.Repeat(".", i+j))
+				res, err := c.SendIPCMessage(c.CreateReq(text, ipc.MSG_MSG, ipc.DATA_TEXT))
+				if err != nil || res.StringData != text {
+					errs <- fmt.Errorf("expected %q, but got %q, %v", text, res.StringData, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,489 @@
+package ipcserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pynezz/pynezzentials"
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// Error codes of the JSON-RPC responses. The ipc.Error is in the data of the error object,
+// the errors without a JSON-RPC equivalent have the code JSONRPC_SERVER_ERROR.
+const (
+	JSONRPC_PARSE_ERROR      = -32700 // The line is not valid JSON
+	JSONRPC_INVALID_REQUEST  = -32600 // The JSON is not a JSON-RPC 2.0 request
+	JSONRPC_METHOD_NOT_FOUND = -32601 // ipc.ErrNoHandler
+	JSONRPC_INVALID_PARAMS   = -32602 // ipc.ErrDecode
+	JSONRPC_INTERNAL_ERROR   = -32603 // ipc.ErrInternal
+	JSONRPC_SERVER_ERROR     = -32000 // Any other ipc.Error
+)
+
+// JSONRPC_CONNECT is the method binding a JSON-RPC connection to a module, with the params {"module": "<name>"}.
+// It stands in for the MSG_CONN handshake, and must be called before any other method.
+const JSONRPC_CONNECT = "ipc.connect"
+
+// JSONRPC_DISCONNECT is the method of the notification sent before the server closes a connection, with the reason
+// as params. It stands in for MSG_DISCONNECT.
+const JSONRPC_DISCONNECT = "ipc.disconnect"
+
+// jsonrpcTypePrefix is the prefix of the methods sending a message type to the handlers, e.g. "ipc.msg" for MSG_MSG
+const jsonrpcTypePrefix = "ipc."
+
+// jsonrpcControlTypes are the message types handled by the connections themselves, which can't be sent as methods
+var jsonrpcControlTypes = map[byte]bool{
+	ipc.MSG_CONN:        true,
+	ipc.MSG_CONNACK:     true,
+	ipc.MSG_PING:        true,
+	ipc.MSG_PONG:        true,
+	ipc.MSG_CANCEL:      true,
+	ipc.MSG_SUBSCRIBE:   true,
+	ipc.MSG_UNSUBSCRIBE: true,
+	ipc.MSG_PUBLISH:     true,
+	ipc.MSG_RPC:         true,
+	ipc.MSG_DISCONNECT:  true,
+	ipc.MSG_ERROR:       true,
+	ipc.MSG_UNKNOWN:     true,
+}
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // Nil for notifications, which are not answered
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonrpcError struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ipc.Error `json:"data,omitempty"`
+}
+
+var jsonrpcNull = json.RawMessage("null")
+
+// jsonrpcSocket is the socket of a server in JSON-RPC compatibility mode
+type jsonrpcSocket struct {
+	path string
+	ln   net.Listener
+	lock *os.File // Lock file next to the socket, nil for abstract sockets
+}
+
+// listenJSONRPC creates the JSON-RPC socket of the server, like listen does for the stream socket
+func (s *IPCServer) listenJSONRPC() (*jsonrpcSocket, error) {
+	j := &jsonrpcSocket{path: s.jsonrpcPath}
+	if ipc.IsAbstractSock(j.path) {
+		ln, err := net.Listen(AF_UNIX, j.path)
+		if err != nil {
+			return nil, err
+		}
+		j.ln = ln
+		return j, nil
+	}
+
+	gid, err := s.socketGid()
+	if err != nil {
+		return nil, err
+	}
+	if err := socketDir(filepath.Dir(j.path), gid); err != nil {
+		return nil, err
+	}
+
+	if j.lock, err = lockSocket(AF_UNIX, j.path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(AF_UNIX, j.path)
+	if err == nil {
+		if err = s.setSocketMode(j.path, gid); err != nil {
+			ln.Close()
+		}
+	}
+	if err != nil {
+		unlockSocket(j.lock)
+		return nil, err
+	}
+	j.ln = ln
+	return j, nil
+}
+
+// close removes the socket, once the listener is closed
+func (j *jsonrpcSocket) close() error {
+	err := j.ln.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	if !ipc.IsAbstractSock(j.path) {
+		if rerr := os.Remove(j.path); rerr != nil && !os.IsNotExist(rerr) {
+			err = errors.Join(err, rerr)
+		}
+	}
+	unlockSocket(j.lock)
+	return err
+}
+
+// acceptJSONRPC serves the connections to the JSON-RPC socket until it is closed
+func (s *IPCServer) acceptJSONRPC(j *jsonrpcSocket) {
+	defer s.connWg.Done()
+
+	for {
+		s.connSlots.acquire()
+		conn, err := j.ln.Accept()
+		if err != nil {
+			s.connSlots.release()
+			if !s.shuttingDown() {
+				s.logger().Error("failed to accept JSON-RPC connection", "err", err)
+			}
+			return
+		}
+
+		cn, ok := s.track(conn)
+		if !ok {
+			s.connSlots.release()
+			conn.Close()
+			return
+		}
+		go func() {
+			defer s.connSlots.release()
+			defer s.untrack(cn)
+			cn.serveJSONRPC()
+		}()
+	}
+}
+
+// jsonrpcConn is a connection speaking JSON-RPC instead of the wire format of the server
+type jsonrpcConn struct {
+	*connection
+	nextId atomic.Uint64 // Message id of the last request
+
+	mu  sync.Mutex // Serializes the responses
+	enc *json.Encoder
+
+	authMu sync.Mutex // Serializes authorize, the requests are not authorized by the read loop
+}
+
+// serveJSONRPC handles the connection like serve, with a JSON-RPC 2.0 request, or a batch of requests, on every line.
+// The calls are turned into requests of the connected module and passed to the handler of the server.
+func (cn *connection) serveJSONRPC() {
+	s := cn.server
+	defer cn.close()
+
+	cn.log = cn.log.With("protocol", "jsonrpc")
+	cn.log.Debug("serving connection")
+	j := &jsonrpcConn{connection: cn, enc: json.NewEncoder(cn.c)}
+	cn.jsonrpc = j
+
+	// Like the MSG_CONN handshake, ipc.connect must be called within ipc.Timeout
+	cn.c.SetReadDeadline(time.Now().Add(ipc.Timeout))
+
+	scanner := bufio.NewScanner(cn.c)
+	scanner.Buffer(make([]byte, 0, 4096), ipc.MAX_FRAME_PAYLOAD)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		cn.hb.Seen()
+
+		if line[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(line, &batch); err != nil {
+				j.send(jsonrpcFailure(jsonrpcNull, JSONRPC_PARSE_ERROR, err.Error()))
+				continue
+			}
+			if len(batch) == 0 {
+				j.send(jsonrpcFailure(jsonrpcNull, JSONRPC_INVALID_REQUEST, "empty batch"))
+				continue
+			}
+			j.start(func() { j.serveBatch(batch) })
+			continue
+		}
+
+		req, res := parseJSONRPC(line)
+		if res != nil {
+			j.send(res)
+			continue
+		}
+		if req.Method == JSONRPC_CONNECT {
+			if res := j.connect(req); res != nil {
+				j.send(res)
+			}
+			if cn.info == nil {
+				break // Refused connections are closed
+			}
+			cn.clearDeadline()
+			continue
+		}
+		j.start(func() {
+			if res := j.serveRequest(req); res != nil {
+				j.send(res)
+			}
+		})
+	}
+	if err := scanner.Err(); err != nil && !s.shuttingDown() {
+		if cn.info == nil && errors.Is(err, os.ErrDeadlineExceeded) {
+			cn.log.Warn("no handshake in time, closing the connection", "timeout", ipc.Timeout)
+		} else {
+			cn.log.Error("failed to read request", "err", err)
+		}
+	}
+
+	// Let the requests finish before the connection is closed
+	cn.wg.Wait()
+}
+
+// start handles the requests of a line on their own goroutine, like the requests of the other connections
+func (j *jsonrpcConn) start(f func()) {
+	j.server.inflight.acquire()
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		defer j.server.inflight.release()
+		f()
+	}()
+}
+
+// serveBatch answers the requests of the batch with an array of responses, without the notifications
+func (j *jsonrpcConn) serveBatch(batch []json.RawMessage) {
+	responses := make([]*jsonrpcResponse, 0, len(batch))
+	for _, raw := range batch {
+		req, res := parseJSONRPC(raw)
+		switch {
+		case res != nil:
+		case req.Method == JSONRPC_CONNECT:
+			res = j.reply(req, nil, fmt.Errorf("%w: %s can't be batched", ipc.ErrInvalidRequest, JSONRPC_CONNECT))
+		default:
+			res = j.serveRequest(req)
+		}
+		if res != nil {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) > 0 {
+		j.send(responses)
+	}
+}
+
+// parseJSONRPC parses the request, or returns the error response if it isn't a valid JSON-RPC 2.0 request
+func parseJSONRPC(data []byte) (*jsonrpcRequest, *jsonrpcResponse) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		if !json.Valid(data) {
+			return nil, jsonrpcFailure(jsonrpcNull, JSONRPC_PARSE_ERROR, err.Error())
+		}
+		return nil, jsonrpcFailure(jsonrpcNull, JSONRPC_INVALID_REQUEST, err.Error())
+	}
+
+	id := req.ID
+	if id == nil {
+		id = jsonrpcNull
+	}
+	if req.Version != "2.0" {
+		return nil, jsonrpcFailure(id, JSONRPC_INVALID_REQUEST, `jsonrpc must be "2.0"`)
+	}
+	if req.Method == "" {
+		return nil, jsonrpcFailure(id, JSONRPC_INVALID_REQUEST, "missing method")
+	}
+	if req.ID != nil {
+		switch req.ID[0] {
+		case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		default:
+			return nil, jsonrpcFailure(jsonrpcNull, JSONRPC_INVALID_REQUEST, "id must be a string, a number or null")
+		}
+	}
+	return &req, nil
+}
+
+// connect binds the connection to the module named in the params, with the handshake of the other connections
+func (j *jsonrpcConn) connect(req *jsonrpcRequest) *jsonrpcResponse {
+	var params struct {
+		Module string `json:"module"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.Module == "" {
+		return j.reply(req, nil, fmt.Errorf(`%w: %s expects {"module": "<name>"}`, ipc.ErrDecode, JSONRPC_CONNECT))
+	}
+
+	id, ok := moduleIdentifier(params.Module)
+	if !ok {
+		err := fmt.Errorf("%w: %s", ipc.ErrUnknownModule, params.Module)
+		j.log.Warn("handshake refused", "err", err)
+		return j.reply(req, nil, err)
+	}
+
+	conn := j.message(id, ipc.MSG_CONN, ipc.DATA_INT, []byte(strconv.Itoa(ipc.PROTOCOL_VERSION)))
+	if _, err := j.handshake(conn); err != nil {
+		j.log.Warn("handshake refused", "err", err)
+		return j.reply(req, nil, err)
+	}
+	result, _ := json.Marshal(j.server.identifier)
+	return j.reply(req, result, nil)
+}
+
+// serveRequest passes the call to the handler of the server, and returns the response, nil for notifications.
+// Methods named after a message type with the "ipc." prefix are sent as that message type, with the params as
+// the data, the other methods are MSG_RPC calls with the params as DATA_JSON.
+func (j *jsonrpcConn) serveRequest(req *jsonrpcRequest) *jsonrpcResponse {
+	if j.info == nil {
+		return j.reply(req, nil, fmt.Errorf("%w: handshake required, call %s first", ipc.ErrUnauthorized, JSONRPC_CONNECT))
+	}
+
+	msg, err := j.request(req)
+	if err != nil {
+		return j.reply(req, nil, err)
+	}
+	j.server.logMessage(j.log, "request received", msg)
+	j.authMu.Lock()
+	err = j.authorize(msg)
+	j.authMu.Unlock()
+	if err != nil {
+		j.log.Warn("rejected request", ipc.MessageAttr(msg), "err", err)
+		return j.reply(req, nil, err)
+	}
+
+	response := j.server.serve(j.ctx, msg)
+	if response.Header.MessageType == ipc.MSG_ERROR {
+		return j.reply(req, nil, ipc.ErrorFromMessage(response))
+	}
+	result, err := jsonrpcResult(response)
+	if err != nil {
+		return j.reply(req, nil, fmt.Errorf("%w: %v", ipc.ErrInternal, err))
+	}
+	return j.reply(req, result, nil)
+}
+
+// request creates the request of the connected module for the call
+func (j *jsonrpcConn) request(req *jsonrpcRequest) (*ipc.IPCRequest, error) {
+	name, ok := strings.CutPrefix(req.Method, jsonrpcTypePrefix)
+	if !ok {
+		data, err := ipc.PackMethod(req.Method, req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return j.message(j.info.Identifier, ipc.MSG_RPC, ipc.DATA_JSON, data), nil
+	}
+
+	msgType, ok := ipc.MSGTYPE[name]
+	if !ok || jsonrpcControlTypes[msgType] {
+		return nil, fmt.Errorf("%w: method %s", ipc.ErrNoHandler.With("method", req.Method), req.Method)
+	}
+	dataType, data := jsonrpcData(req.Params)
+	return j.message(j.info.Identifier, msgType, dataType, data), nil
+}
+
+// message creates a request with the next message id of the connection
+func (j *jsonrpcConn) message(identifier [4]byte, msgType byte, dataType ipc.DataType, data []byte) *ipc.IPCRequest {
+	return &ipc.IPCRequest{
+		MessageSignature: ipc.IPCID,
+		Header: ipc.IPCHeader{
+			Identifier:  identifier,
+			MessageType: msgType,
+			MessageId:   ipc.IPCMessageId(j.nextId.Add(1)),
+		},
+		Message: ipc.IPCMessage{
+			Datatype:   dataType,
+			Data:       data,
+			StringData: string(data),
+		},
+		Timestamp:  pynezzentials.UnixNanoTimestamp(),
+		Checksum32: int(crc(data)),
+	}
+}
+
+// jsonrpcData returns the params as the data of a message: strings as DATA_TEXT, integers as DATA_INT,
+// and anything else as DATA_JSON
+func jsonrpcData(params json.RawMessage) (ipc.DataType, []byte) {
+	var s string
+	if err := json.Unmarshal(params, &s); err == nil {
+		return ipc.DATA_TEXT, []byte(s)
+	}
+	if _, err := strconv.ParseInt(string(params), 10, 64); err == nil {
+		return ipc.DATA_INT, params
+	}
+	return ipc.DATA_JSON, params
+}
+
+// jsonrpcResult returns the data of the response as the result of the call.
+// JSON is passed as is, the other datatypes are decoded with ipc.DecodeData, so text becomes a string,
+// integers a number, and binary data a base64 string.
+func jsonrpcResult(res *ipc.IPCRequest) (json.RawMessage, error) {
+	data := res.Message.Data
+	if len(data) == 0 {
+		return jsonrpcNull, nil
+	}
+	if res.Message.Datatype == ipc.DATA_JSON && json.Valid(data) {
+		return data, nil
+	}
+
+	var v any
+	if err := ipc.DecodeData(res.Message.Datatype, data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// reply returns the response to the request with the result or the error, nil for notifications
+func (j *jsonrpcConn) reply(req *jsonrpcRequest, result json.RawMessage, err error) *jsonrpcResponse {
+	if req.ID == nil {
+		if err != nil {
+			j.log.Debug("notification failed", "method", req.Method, "err", err)
+		}
+		return nil
+	}
+	if err != nil {
+		e := ipc.AsError(err)
+		code := JSONRPC_SERVER_ERROR
+		switch e.Code {
+		case ipc.CODE_NO_HANDLER:
+			code = JSONRPC_METHOD_NOT_FOUND
+		case ipc.CODE_DECODE:
+			code = JSONRPC_INVALID_PARAMS
+		case ipc.CODE_INTERNAL:
+			code = JSONRPC_INTERNAL_ERROR
+		}
+		return &jsonrpcResponse{Version: "2.0", Error: &jsonrpcError{Code: code, Message: e.Message, Data: e}, ID: req.ID}
+	}
+	return &jsonrpcResponse{Version: "2.0", Result: result, ID: req.ID}
+}
+
+// jsonrpcFailure returns the response for a request that couldn't be parsed
+func jsonrpcFailure(id json.RawMessage, code int, message string) *jsonrpcResponse {
+	return &jsonrpcResponse{Version: "2.0", Error: &jsonrpcError{Code: code, Message: message}, ID: id}
+}
+
+// notify sends a notification to the client, on its own line
+func (j *jsonrpcConn) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(&jsonrpcRequest{Version: "2.0", Method: method, Params: data})
+}
+
+// send writes the response, or the batch of responses, on its own line
+func (j *jsonrpcConn) send(v any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.enc.Encode(v); err != nil {
+		j.log.Error("failed to respond", "err", err)
+		j.c.Close() // Unblocks the read loop
+	}
+}
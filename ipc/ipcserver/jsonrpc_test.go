@@ -0,0 +1,203 @@
+package ipcserver_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int        `json:"code"`
+		Message string     `json:"message"`
+		Data    *ipc.Error `json:"data"`
+	} `json:"error"`
+	ID json.RawMessage `json:"id"`
+}
+
+// jsonrpcClient is a line-based JSON-RPC client, like socat on the JSON-RPC socket
+type jsonrpcClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// jsonrpcServer starts a server in JSON-RPC compatibility mode, with the test methods and an echo for MSG_MSG,
+// and returns the path of the JSON-RPC socket
+func jsonrpcServer(t *testing.T) string {
+	t.Helper()
+
+	ipcserver.AddModule("jsonrpc", []byte("JSON"))
+	path := filepath.Join(t.TempDir(), "jsonrpc.sock")
+	startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+		registerTestMethods(s)
+	}, ipcserver.WithJSONRPCSocket(path))
+	waitForSocket(t, path)
+	return path
+}
+
+func dialJSONRPC(t *testing.T, path string) *jsonrpcClient {
+	t.Helper()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to dial the JSON-RPC socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &jsonrpcClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// call sends the line, and returns the next line from the server
+func (c *jsonrpcClient) call(line string) string {
+	c.t.Helper()
+
+	if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+		c.t.Fatalf("Failed to send %s: %v", line, err)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Failed to read the response to %s: %v", line, err)
+	}
+	return res
+}
+
+// response sends the request, and returns the decoded response
+func (c *jsonrpcClient) response(line string) jsonrpcResponse {
+	c.t.Helper()
+
+	var res jsonrpcResponse
+	if err := json.Unmarshal([]byte(c.call(line)), &res); err != nil {
+		c.t.Fatalf("Failed to decode the response to %s: %v", line, err)
+	}
+	if res.Version != "2.0" {
+		c.t.Errorf("Expected a JSON-RPC 2.0 response, but got %+v", res)
+	}
+	return res
+}
+
+// result sends the request, and returns the result of the call
+func (c *jsonrpcClient) result(line string) string {
+	c.t.Helper()
+
+	res := c.response(line)
+	if res.Error != nil {
+		c.t.Fatalf("Expected a result for %s, but got error %d: %s", line, res.Error.Code, res.Error.Message)
+	}
+	return string(res.Result)
+}
+
+// TestJSONRPC tests that JSON-RPC calls reach the methods and the handlers of the server
+func TestJSONRPC(t *testing.T) {
+	c := dialJSONRPC(t, jsonrpcServer(t))
+
+	if res := c.result(`{"jsonrpc": "2.0", "method": "ipc.connect", "params": {"module": "jsonrpc"}, "id": 1}`); res != `"TEST"` {
+		t.Errorf("Expected the server identifier, but got %s", res)
+	}
+
+	tests := []struct {
+		request string
+		result  string
+	}{
+		{`{"jsonrpc": "2.0", "method": "scan", "params": {"target": "10.0.0.1", "ports": [22, 80]}, "id": 2}`, `{"open":[22]}`},
+		{`{"jsonrpc": "2.0", "method": "count", "id": "three"}`, `3`},
+		{`{"jsonrpc": "2.0", "method": "ipc.msg", "params": "hello", "id": 4}`, `"hello"`},
+	}
+	for _, test := range tests {
+		if res := c.result(test.request); res != test.result {
+			t.Errorf("Expected %s for %s, but got %s", test.result, test.request, res)
+		}
+	}
+
+	// Notifications are handled, but never answered, so the next line answers the request after them
+	res := c.response(`{"jsonrpc": "2.0", "method": "ipc.msg", "params": "ignored"}` + "\n" + `{"jsonrpc": "2.0", "method": "count", "id": 5}`)
+	if string(res.ID) != "5" {
+		t.Errorf("Expected the response to request 5, but got %s", res.ID)
+	}
+
+	batch := c.call(`[{"jsonrpc": "2.0", "method": "count", "id": 6}, {"jsonrpc": "2.0", "method": "count"}, {"jsonrpc": "2.0", "method": "missing", "id": 7}]`)
+	var responses []jsonrpcResponse
+	if err := json.Unmarshal([]byte(batch), &responses); err != nil || len(responses) != 2 {
+		t.Fatalf("Expected two responses in the batch, but got %s, %v", batch, err)
+	}
+	if string(responses[0].Result) != "3" || responses[1].Error == nil || responses[1].Error.Code != ipcserver.JSONRPC_METHOD_NOT_FOUND {
+		t.Errorf("Expected the count and a missing method, but got %s", batch)
+	}
+}
+
+// TestJSONRPCErrors tests that invalid requests and the errors of the handlers map to JSON-RPC errors
+func TestJSONRPCErrors(t *testing.T) {
+	path := jsonrpcServer(t)
+	c := dialJSONRPC(t, path)
+
+	res := c.response(`{"jsonrpc": "2.0", "method": "count", "id": 1}`)
+	if res.Error == nil || !errors.Is(res.Error.Data, ipc.ErrUnauthorized) {
+		t.Fatalf("Expected ErrUnauthorized before ipc.connect, but got %+v", res)
+	}
+	c.result(`{"jsonrpc": "2.0", "method": "ipc.connect", "params": {"module": "jsonrpc"}, "id": 2}`)
+
+	tests := []struct {
+		request string
+		code    int
+		err     error
+	}{
+		{`{"jsonrpc": "2.0", "method": `, ipcserver.JSONRPC_PARSE_ERROR, nil},
+		{`{"jsonrpc": "1.0", "method": "count", "id": 3}`, ipcserver.JSONRPC_INVALID_REQUEST, nil},
+		{`{"jsonrpc": "2.0", "method": 5, "id": 4}`, ipcserver.JSONRPC_INVALID_REQUEST, nil},
+		{`[]`, ipcserver.JSONRPC_INVALID_REQUEST, nil},
+		{`{"jsonrpc": "2.0", "method": "missing", "id": 5}`, ipcserver.JSONRPC_METHOD_NOT_FOUND, ipc.ErrNoHandler},
+		{`{"jsonrpc": "2.0", "method": "ipc.ping", "id": 6}`, ipcserver.JSONRPC_METHOD_NOT_FOUND, ipc.ErrNoHandler},
+		{`{"jsonrpc": "2.0", "method": "scan", "params": "10.0.0.1", "id": 7}`, ipcserver.JSONRPC_INVALID_PARAMS, ipc.ErrDecode},
+		{`{"jsonrpc": "2.0", "method": "scan", "params": {}, "id": 8}`, ipcserver.JSONRPC_SERVER_ERROR, ipc.ErrInvalidRequest},
+		{`{"jsonrpc": "2.0", "method": "ipc.connect", "params": {"module": "jsonrpc"}, "id": 9}`, ipcserver.JSONRPC_SERVER_ERROR, ipc.ErrInvalidRequest},
+	}
+	for _, test := range tests {
+		res := c.response(test.request)
+		if res.Error == nil || res.Error.Code != test.code {
+			t.Errorf("Expected error %d for %s, but got %+v", test.code, test.request, res)
+			continue
+		}
+		if test.err != nil && !errors.Is(res.Error.Data, test.err) {
+			t.Errorf("Expected %v in the data of the error for %s, but got %+v", test.err, test.request, res.Error.Data)
+		}
+	}
+
+	// Unknown modules are refused, and their connection closed
+	c = dialJSONRPC(t, path)
+	res = c.response(`{"jsonrpc": "2.0", "method": "ipc.connect", "params": {"module": "nobody"}, "id": 1}`)
+	if res.Error == nil || !errors.Is(res.Error.Data, ipc.ErrUnknownModule) {
+		t.Errorf("Expected ErrUnknownModule, but got %+v", res)
+	}
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, but got %v", err)
+	}
+}
+
+// TestJSONRPCConnectTimeout tests that a connection that doesn't call ipc.connect in time is closed
+func TestJSONRPCConnectTimeout(t *testing.T) {
+	path := jsonrpcServer(t)
+
+	idle := dialJSONRPC(t, path)
+	idle.conn.SetReadDeadline(time.Now().Add(ipc.Timeout + 5*time.Second))
+	if _, err := idle.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, but got %v", err)
+	}
+
+	c := dialJSONRPC(t, path)
+	c.result(`{"jsonrpc": "2.0", "method": "ipc.connect", "params": {"module": "jsonrpc"}, "id": 1}`)
+	time.Sleep(ipc.Timeout + 200*time.Millisecond)
+	if res := c.result(`{"jsonrpc": "2.0", "method": "count", "id": 2}`); res != "3" {
+		t.Errorf("Expected the connected module to stay connected, but got %s", res)
+	}
+}
@@ -0,0 +1,112 @@
+package ipcserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrServerRunning is returned by Serve and InitServerSocket when another server is running on the socket
+var ErrServerRunning = errors.New("ipcserver: server already running")
+
+// errLocked is returned by flock when another process holds the lock
+var errLocked = errors.New("locked")
+
+// probeTimeout is how long a server on an existing socket has to answer before the socket is considered stale
+const probeTimeout = time.Second
+
+// lock takes the lock of the stream socket of the server, see lockSocket
+func (s *IPCServer) lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockFile != nil {
+		return nil // Held already
+	}
+
+	f, err := lockSocket(AF_UNIX, s.path)
+	if err != nil {
+		return err
+	}
+	s.lockFile = f
+	return nil
+}
+
+// unlock releases the lock of the stream socket of the server, if it is held
+func (s *IPCServer) unlock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlockSocket(s.lockFile)
+	s.lockFile = nil
+}
+
+// lockSocket takes the lock file next to the socket and writes the PID of the process to it, then removes the socket
+// if it is stale. The lock file is kept when the lock is released, removing it would let two servers lock it.
+func lockSocket(network string, sock string) (*os.File, error) {
+	path := sock + ".lock"
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		pid, _ := io.ReadAll(io.LimitReader(f, 32))
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("%w: %s is locked by pid %s", ErrServerRunning, path, strings.TrimSpace(string(pid)))
+		}
+		return nil, fmt.Errorf("ipcserver: lock %s: %w", path, err)
+	}
+
+	if err := removeStaleSocket(network, sock); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("ipcserver: write %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// unlockSocket releases the lock file, if it isn't nil
+func unlockSocket(f *os.File) {
+	if f == nil {
+		return
+	}
+	f.Truncate(0) // No PID, no server
+	f.Close()     // Releases the lock
+}
+
+// removeStaleSocket removes the socket file, unless a server answers on it
+func removeStaleSocket(network string, path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("ipcserver: %s exists and is not a socket", path)
+	}
+
+	c, err := net.DialTimeout(network, path, probeTimeout)
+	if err == nil {
+		c.Close()
+		return fmt.Errorf("%w: a server answers on %s", ErrServerRunning, path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("ipcserver: probe %s: %w", path, err) // Can't tell if the server is alive
+	}
+	return os.Remove(path)
+}
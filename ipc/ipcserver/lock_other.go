@@ -0,0 +1,10 @@
+//go:build !unix || aix
+
+package ipcserver
+
+import "os"
+
+// flock is not supported on the platform, the probe of the socket is the only check for a running server
+func flock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build unix && !aix
+
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestServerRunning tests that a second server on the socket refuses to start, and leaves the running one alone
+func TestServerRunning(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+
+	lock, err := os.ReadFile(path + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := strings.TrimSpace(string(lock)); pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Expected the PID %d in the lock file, but got %q", os.Getpid(), pid)
+	}
+
+	second := ipcserver.NewIPCServer("test", "TEST", ipcserver.WithSocketPath(path))
+	if err := second.Serve(context.Background()); !errors.Is(err, ipcserver.ErrServerRunning) {
+		t.Errorf("Expected ErrServerRunning, but got %v", err)
+	}
+	if err := second.Shutdown(context.Background()); err != nil {
+		t.Errorf("Failed to shut down the refused server: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the socket of the running server to be kept, but got %v", err)
+	}
+
+	c := connect(t, path, "FRST")
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the first server to keep serving, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestStaleSocket tests that the socket left by a server that is no longer running is replaced
+func TestStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetUnlinkOnClose(false) // Like a crashed server
+	ln.Close()
+
+	serveAt(t, path, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+	c := connect(t, path, "STAL")
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the new server to serve, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestInitServerSocket tests that a live server without a lock file is detected by probing its socket
+func TestInitServerSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server := ipcserver.NewIPCServer("test", "TEST", ipcserver.WithSocketPath(path))
+	if err := server.InitServerSocket(); !errors.Is(err, ipcserver.ErrServerRunning) {
+		t.Errorf("Expected ErrServerRunning, but got %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the socket of the running server to be kept, but got %v", err)
+	}
+}
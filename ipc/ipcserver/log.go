@@ -0,0 +1,42 @@
+package ipcserver
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// logger returns the logger of the server
+func (s *IPCServer) logger() *slog.Logger {
+	if s.log != nil {
+		return s.log
+	}
+	return ipc.Logger()
+}
+
+// loggerKey is the context key of the logger of a request that was not received on a connection, like a datagram
+type loggerKey struct{}
+
+// loggerFrom returns the logger for a request, with the module of the connection it was received on
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if cn, ok := ctx.Value(connKey{}).(*connection); ok {
+		return cn.log
+	}
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return ipc.Logger()
+}
+
+// logMessage logs a message at debug level, with its payload if payload logging is enabled
+func (s *IPCServer) logMessage(log *slog.Logger, msg string, req *ipc.IPCRequest) {
+	if !log.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	if s.logPayloads {
+		log.Debug(msg, ipc.MessageAttr(req), ipc.PayloadAttr(req, s.redact))
+	} else {
+		log.Debug(msg, ipc.MessageAttr(req))
+	}
+}
@@ -0,0 +1,76 @@
+package ipcserver_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// logBuffer is a bytes.Buffer safe for the concurrent writes of the server
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func debugLogger(w *logBuffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// TestPayloadLogging tests that payloads are only logged when enabled, and as returned by the redactor
+func TestPayloadLogging(t *testing.T) {
+	const secret = "password=hunter2"
+
+	tests := []struct {
+		name     string
+		opts     []ipcserver.Option
+		expected string // In the log, if not empty
+	}{
+		{"default", nil, ""},
+		{"redacted", []ipcserver.Option{ipcserver.WithPayloadLogging(func(msg *ipc.IPCRequest) string {
+			return strings.ReplaceAll(msg.Message.StringData, "hunter2", "[REDACTED]")
+		})}, "password=[REDACTED]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf logBuffer
+			opts := append([]ipcserver.Option{ipcserver.WithLogger(debugLogger(&buf))}, tt.opts...)
+			path := startServer(t, func(s *ipcserver.IPCServer) {
+				s.HandleFunc(ipc.MSG_MSG, echo)
+			}, opts...)
+			c := connect(t, path, "LOGS")
+
+			if _, err := c.SendIPCMessage(c.CreateReq(secret, ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+				t.Fatal(err)
+			}
+
+			log := buf.String()
+			if !strings.Contains(log, "request received") {
+				t.Errorf("Expected the request to be logged, but got:\n%s", log)
+			}
+			if strings.Contains(log, "hunter2") {
+				t.Errorf("Expected the secret not to be logged, but got:\n%s", log)
+			}
+			if tt.expected != "" && !strings.Contains(log, tt.expected) {
+				t.Errorf("Expected %q in the log, but got:\n%s", tt.expected, log)
+			}
+		})
+	}
+}
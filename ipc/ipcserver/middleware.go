@@ -0,0 +1,148 @@
+package ipcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+var (
+	ErrChecksum        = ipc.ErrChecksum                                                          // The checksum of the request doesn't match its data
+	ErrUnauthenticated = fmt.Errorf("%w: request without a verified module", ipc.ErrUnauthorized) // The request was not received on a connection bound to a module
+	ErrForbidden       = fmt.Errorf("%w: module not allowed", ipc.ErrUnauthorized)                // The module of the connection is not allowed by the handler
+)
+
+// Middleware wraps a Handler, to process the requests before and after it.
+//
+// Example:
+//
+//	func Audit(next ipcserver.Handler) ipcserver.Handler {
+//		return ipcserver.HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+//			info, _ := ipcserver.ConnInfoFromContext(ctx)
+//			slog.Info("request", "module", info.Module, "type", req.Header.MessageType)
+//			return next.ServeIPC(ctx, req)
+//		})
+//	}
+//
+//	server.Use(Audit)
+type Middleware func(Handler) Handler
+
+// Chain wraps the handler in the middleware. The first middleware is the outermost, it sees the request first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for _, mw := range slices.Backward(middleware) {
+		h = mw(h)
+	}
+	return h
+}
+
+// DefaultMiddleware returns the middleware a server uses unless WithMiddleware is given:
+// Recover, Checksum, Metadata and Timing.
+func DefaultMiddleware() []Middleware {
+	return []Middleware{Recover(), Checksum(), Metadata(), Timing()}
+}
+
+// Use appends the middleware to the middleware of the server.
+// It must be called before Serve.
+func (s *IPCServer) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Recover turns a panic in the handler into an error response, instead of crashing the server
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (response *ipc.IPCRequest, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					loggerFrom(ctx).Error("recovered from panic in handler", ipc.MessageAttr(req), "panic", r, "stack", string(debug.Stack()))
+					response, err = nil, fmt.Errorf("%w handling message type 0x%02x", ipc.ErrInternal, req.Header.MessageType)
+				}
+			}()
+			return next.ServeIPC(ctx, req)
+		})
+	}
+}
+
+// Logging logs every request and the outcome of handling it at info level, with the logger of the server
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			log := loggerFrom(ctx).With(ipc.MessageAttr(req))
+
+			response, err := next.ServeIPC(ctx, req)
+			switch {
+			case err != nil:
+				log.Warn("request failed", "err", err)
+			case response != nil:
+				log.Info("request answered", "response_type", fmt.Sprintf("0x%02x", response.Header.MessageType))
+			default:
+				log.Info("request acknowledged")
+			}
+			return response, err
+		})
+	}
+}
+
+// Checksum rejects the requests whose checksum doesn't match their data, with ErrChecksum
+func Checksum() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			if req.Checksum32 != int(crc(req.Message.Data)) {
+				return nil, ErrChecksum.With("calculated", strconv.FormatUint(uint64(crc(req.Message.Data)), 10))
+			}
+			return next.ServeIPC(ctx, req)
+		})
+	}
+}
+
+// Authentication rejects the requests that were not received on a connection bound to a module by the handshake.
+// If modules are given, only the requests of those modules are let through, the others are rejected with ErrForbidden.
+func Authentication(modules ...string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			info, ok := ConnInfoFromContext(ctx)
+			if !ok {
+				return nil, ErrUnauthenticated
+			}
+			if len(modules) > 0 && !slices.Contains(modules, info.Module) {
+				return nil, fmt.Errorf("%w: %s", ErrForbidden, info.Module)
+			}
+			return next.ServeIPC(ctx, req)
+		})
+	}
+}
+
+// Timing logs how long the handler took at debug level, and the response time since the client sent the request
+func Timing() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			start := time.Now()
+			response, err := next.ServeIPC(ctx, req)
+			loggerFrom(ctx).Debug("request handled", ipc.MessageAttr(req), "duration", time.Since(start), "response_time", responseTime(req.Timestamp))
+			return response, err
+		})
+	}
+}
+
+// Metadata decodes the metadata of the structured (JSON, YAML) requests into the context, see MetadataFromContext.
+// Requests with invalid metadata are rejected with an error wrapping ipc.ErrInvalidMetadata.
+func Metadata() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			md, ctx, err := requestMetadata(ctx, req)
+			switch {
+			case errors.Is(err, ipc.ErrNoMetadata):
+			case err != nil:
+				return nil, err
+			default:
+				loggerFrom(ctx).Debug(md.Metadata.String(), "method", md.Metadata.Method, "object", md.Metadata.Destination.Object.Name)
+			}
+			return next.ServeIPC(ctx, req)
+		})
+	}
+}
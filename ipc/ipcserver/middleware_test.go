@@ -0,0 +1,114 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// record returns a middleware that appends its name to the trace, before and after the handler
+func record(name string, trace *[]string) ipcserver.Middleware {
+	return func(next ipcserver.Handler) ipcserver.Handler {
+		return ipcserver.HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			*trace = append(*trace, name)
+			res, err := next.ServeIPC(ctx, req)
+			*trace = append(*trace, name)
+			return res, err
+		})
+	}
+}
+
+// TestChain tests that the first middleware is the outermost
+func TestChain(t *testing.T) {
+	var trace []string
+	h := ipcserver.Chain(ipcserver.HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+		trace = append(trace, "handler")
+		return nil, nil
+	}), record("a", &trace), record("b", &trace))
+
+	if _, err := h.ServeIPC(context.Background(), &ipc.IPCRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(trace, " "); got != "a b handler b a" {
+		t.Errorf("Expected the middleware to run in order, but got %q", got)
+	}
+}
+
+// TestRecover tests that a panicking handler is answered with an error, and the server keeps serving
+func TestRecover(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			if req.Message.StringData == "panic" {
+				panic("handler bug")
+			}
+			return echo(ctx, req)
+		})
+	})
+	c := connect(t, path, "PANC")
+
+	if _, err := c.SendIPCMessage(c.CreateReq("panic", ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, ipc.ErrInternal) {
+		t.Errorf("Expected an internal error, but got %v", err)
+	}
+	if res, _ := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); res.StringData != "hello" {
+		t.Errorf("Expected the server to keep serving after a panic, but got %q", res.StringData)
+	}
+}
+
+// TestChecksum tests that requests with a checksum that doesn't match their data are rejected
+func TestChecksum(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+	ipcserver.AddModule("module CSUM", []byte("CSUM"))
+
+	c := dial(t, path)
+	id := [4]byte{'C', 'S', 'U', 'M'}
+	roundTrip(t, c, ipc.IPCRequest{
+		Header:  ipc.IPCHeader{Identifier: id, MessageType: ipc.MSG_CONN},
+		Message: ipc.IPCMessage{Datatype: ipc.DATA_INT, Data: []byte(strconv.Itoa(ipc.PROTOCOL_VERSION))},
+	})
+
+	err := c.Send(&ipc.IPCRequest{
+		Header:     ipc.IPCHeader{Identifier: id, MessageType: ipc.MSG_MSG},
+		Message:    ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("hello")},
+		Checksum32: 1234,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.MessageType != ipc.MSG_ERROR || !errors.Is(ipc.ErrorFromMessage(&res), ipcserver.ErrChecksum) {
+		t.Errorf("Expected %v, but got message type 0x%02x: %s", ipcserver.ErrChecksum, res.Header.MessageType, res.Message.StringData)
+	}
+}
+
+// TestAuthentication tests that the Authentication middleware only lets the listed modules through
+func TestAuthentication(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+		s.Use(ipcserver.Authentication("module ALOW"))
+	})
+	allowed, denied := connect(t, path, "ALOW"), connect(t, path, "DENY")
+
+	if res, _ := allowed.SendIPCMessage(allowed.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); res.StringData != "hello" {
+		t.Errorf("Expected the allowed module to be served, but got %q", res.StringData)
+	}
+	_, err := denied.SendIPCMessage(denied.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT))
+	if !errors.Is(err, ipc.ErrUnauthorized) || !strings.Contains(err.Error(), ipcserver.ErrForbidden.Error()) {
+		t.Errorf("Expected the other module to be forbidden, but got %v", err)
+	}
+
+	// Outside of a connection there is no module at all
+	h := ipcserver.Chain(ipcserver.HandlerFunc(echo), ipcserver.Authentication())
+	if _, err := h.ServeIPC(context.Background(), &ipc.IPCRequest{}); !errors.Is(err, ipcserver.ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated, but got %v", err)
+	}
+}
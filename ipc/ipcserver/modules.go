@@ -0,0 +1,535 @@
+package ipcserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+var (
+	ErrInvalidModule   = errors.New("invalid module definition")   // A module definition is incomplete or malformed
+	ErrDuplicateModule = errors.New("duplicate module definition") // A name or identifier is defined more than once
+)
+
+// definitions are the loaded module definitions by name, guarded by modulesMu.
+// Modules added with AddModule have no definition, and are not restricted beyond their allowlist.
+var definitions = map[string]*moduleDef{}
+
+// Module is the definition of a module allowed to connect to the server.
+//
+// Example, in a YAML module definitions file:
+//
+//	modules:
+//	  - name: sigma
+//	    identifier: SIGM
+//	    description: sigma rules module
+//	    message_types: [msg, subscribe, publish]
+//	    uid: sigma
+//	    rate_limit:
+//	      rate: 50
+//	      burst: 100
+//	    cert: sigma.modules.example.com
+type Module struct {
+	Name         string    `yaml:"name" json:"name"`
+	Identifier   string    `yaml:"identifier" json:"identifier"` // Identifier of the module in the message headers, at most 4 bytes
+	Description  string    `yaml:"description,omitempty" json:"description,omitempty"`
+	MessageTypes []string  `yaml:"message_types,omitempty" json:"message_types,omitempty"` // Message types the module may send, as named in ipc.MSGTYPE. All if empty.
+	UID          IDs       `yaml:"uid,omitempty" json:"uid,omitempty"`                     // Users allowed to connect as the module, see Allowlist
+	GID          IDs       `yaml:"gid,omitempty" json:"gid,omitempty"`                     // Groups allowed to connect as the module, see Allowlist
+	RateLimit    RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	Cert         string    `yaml:"cert,omitempty" json:"cert,omitempty"` // Common name or DNS name of the client certificate of the module over mutual TLS, the name of the module if empty
+}
+
+// RateLimit limits the requests of a module, per connection
+type RateLimit struct {
+	Rate  float64 `yaml:"rate" json:"rate"`                       // Requests per second, unlimited if 0
+	Burst int     `yaml:"burst,omitempty" json:"burst,omitempty"` // Requests allowed at once, the rate rounded up if 0
+}
+
+// IDs are user or group names or ids. In a module definitions file they are a single value or a list.
+type IDs []string
+
+func (ids *IDs) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	return ids.set(v)
+}
+
+func (ids *IDs) UnmarshalYAML(n *yaml.Node) error {
+	var v any
+	if err := n.Decode(&v); err != nil {
+		return err
+	}
+	return ids.set(v)
+}
+
+func (ids *IDs) set(v any) error {
+	values, ok := v.([]any)
+	if !ok {
+		values = []any{v}
+	}
+
+	*ids = nil
+	for _, value := range values {
+		switch value := value.(type) {
+		case nil:
+		case string:
+			*ids = append(*ids, value)
+		case int:
+			*ids = append(*ids, strconv.Itoa(value))
+		case float64:
+			*ids = append(*ids, strconv.FormatFloat(value, 'f', -1, 64))
+		default:
+			return fmt.Errorf("invalid id %v", value)
+		}
+	}
+	return nil
+}
+
+// ModuleError is an error in a module definitions file
+type ModuleError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *ModuleError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ModuleError) Unwrap() error {
+	return e.Err
+}
+
+// moduleDef is a validated module definition
+type moduleDef struct {
+	Module
+	allowlist Allowlist
+	types     map[byte]bool // Message types the module may send, nil allows all
+}
+
+// Validate checks the module definition. The users and groups are looked up on the system.
+func (m Module) Validate() error {
+	_, err := m.compile()
+	return err
+}
+
+func (m Module) compile() (*moduleDef, error) {
+	if m.Name == "" || strings.ContainsFunc(m.Name, unicode.IsSpace) {
+		return nil, fmt.Errorf("%w: invalid module name %q", ErrInvalidModule, m.Name)
+	}
+	if err := checkIdentifier(m.Name, []byte(m.Identifier)); err != nil {
+		return nil, err
+	}
+
+	def := &moduleDef{Module: m}
+
+	var attributes []string
+	if len(m.UID) > 0 {
+		attributes = append(attributes, "uid="+strings.Join(m.UID, ","))
+	}
+	if len(m.GID) > 0 {
+		attributes = append(attributes, "gid="+strings.Join(m.GID, ","))
+	}
+	allowlist, err := ParseAllowlist(attributes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: module %s: %v", ErrInvalidModule, m.Name, err)
+	}
+	def.allowlist = allowlist
+
+	for _, name := range m.MessageTypes {
+		t, ok := ipc.MSGTYPE[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: module %s: unknown message type %q", ErrInvalidModule, m.Name, name)
+		}
+		if def.types == nil {
+			def.types = map[byte]bool{}
+		}
+		def.types[t] = true
+	}
+
+	if m.RateLimit.Rate < 0 || m.RateLimit.Burst < 0 {
+		return nil, fmt.Errorf("%w: module %s: negative rate limit", ErrInvalidModule, m.Name)
+	}
+	return def, nil
+}
+
+// checkIdentifier checks the identifier of the named module: at most 4 bytes, padded with zeros in the message headers.
+// Module definitions and AddModule both apply it.
+func checkIdentifier(name string, id []byte) error {
+	if len(id) == 0 || len(id) > 4 {
+		return fmt.Errorf("%w: identifier %q of module %s must be 1 to 4 bytes, not %d", ErrInvalidModule, id, name, len(id))
+	}
+	return nil
+}
+
+// allows returns true if the module may send the message type
+func (def *moduleDef) allows(msgType byte) bool {
+	return def.types == nil || def.types[msgType]
+}
+
+// LoadModules loads the module definitions file at the path, and adds the modules to the server.
+//
+// Files ending in .yaml or .yml are read as YAML, and files ending in .json as JSON, with the modules
+// listed under "modules" (see Module). Any other file is read in the legacy line format of config.txt:
+//
+//	module [4]byte [uid=user] [gid=group] [types=msg,publish] [rate=50] [burst=100] [cert=name] :: Description
+//
+// The file is loaded as a whole: if any definition is invalid, no module is added, and the returned error
+// joins a *ModuleError with the line number of every invalid definition.
+func LoadModules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ipcserver: load modules: %w", err)
+	}
+
+	defs, err := parseModules(path, data)
+	if err != nil {
+		return err
+	}
+
+	modulesMu.Lock()
+	for _, def := range defs {
+		addModule(def)
+	}
+	modulesMu.Unlock()
+
+	ipc.Logger().Debug("loaded modules", "path", path, "modules", len(defs))
+	return nil
+}
+
+// RegisterModule validates the module definition and adds the module to the server
+func RegisterModule(m Module) error {
+	def, err := m.compile()
+	if err != nil {
+		return err
+	}
+
+	modulesMu.Lock()
+	addModule(def)
+	modulesMu.Unlock()
+	return nil
+}
+
+// addModule adds the module, replacing the module with the same name. modulesMu must be held.
+func addModule(def *moduleDef) {
+	MODULEIDENTIFIERS[def.Name] = []byte(def.Identifier)
+	definitions[def.Name] = def
+	if def.allowlist.Empty() {
+		delete(MODULECREDENTIALS, def.Name)
+	} else {
+		MODULECREDENTIALS[def.Name] = def.allowlist
+	}
+}
+
+// moduleDefinition returns the definition of the named module, nil if it has none
+func moduleDefinition(name string) *moduleDef {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	return definitions[name]
+}
+
+// lineModule is a module definition and the line it starts on
+type lineModule struct {
+	line int
+	Module
+}
+
+// parseModules parses and validates the module definitions of the file
+func parseModules(path string, data []byte) ([]*moduleDef, error) {
+	var (
+		modules []lineModule
+		errs    []error
+	)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		modules, errs = parseYAMLModules(data)
+	case ".json":
+		modules, errs = parseJSONModules(data)
+	default:
+		modules, errs = parseLegacyModules(data)
+	}
+
+	names := map[string]int{}
+	identifiers := map[string]int{}
+	defs := make([]*moduleDef, 0, len(modules))
+	for _, m := range modules {
+		def, err := m.compile()
+		if err != nil {
+			errs = append(errs, &ModuleError{Path: path, Line: m.line, Err: err})
+			continue
+		}
+		if line, ok := names[m.Name]; ok {
+			errs = append(errs, &ModuleError{Path: path, Line: m.line, Err: fmt.Errorf("%w: module %s is already defined on line %d", ErrDuplicateModule, m.Name, line)})
+			continue
+		}
+		if line, ok := identifiers[m.Identifier]; ok {
+			errs = append(errs, &ModuleError{Path: path, Line: m.line, Err: fmt.Errorf("%w: identifier %s is already used on line %d", ErrDuplicateModule, m.Identifier, line)})
+			continue
+		}
+		names[m.Name] = m.line
+		identifiers[m.Identifier] = m.line
+		defs = append(defs, def)
+	}
+
+	for i, err := range errs {
+		if me, ok := err.(*ModuleError); ok {
+			me.Path = path
+		} else {
+			errs[i] = fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return defs, nil
+}
+
+// parseLegacyModules parses the line format of config.txt
+func parseLegacyModules(data []byte) ([]lineModule, []error) {
+	var (
+		modules []lineModule
+		errs    []error
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if len(line) < 1 {
+			// empty line
+			continue
+		}
+		firstChar := line[0]
+		if firstChar == '#' || firstChar == ' ' || firstChar == '\t' || firstChar == '/' || firstChar == '*' || firstChar == '\n' || firstChar == '\r' {
+			// comment
+			continue
+		}
+
+		definition, description, _ := strings.Cut(line, "::") // The description follows the ::
+		parts := strings.Fields(definition)
+		if len(parts) < 2 {
+			errs = append(errs, &ModuleError{Line: n, Err: fmt.Errorf("%w: missing module identifier", ErrInvalidModule)})
+			continue
+		}
+
+		m := Module{Name: parts[0], Identifier: parts[1], Description: strings.TrimSpace(description)}
+		if err := m.parseAttributes(parts[2:]); err != nil {
+			errs = append(errs, &ModuleError{Line: n, Err: fmt.Errorf("%w: module %s: %v", ErrInvalidModule, m.Name, err)})
+			continue
+		}
+		modules = append(modules, lineModule{n, m})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return modules, errs
+}
+
+// parseAttributes parses the key=value attributes following the identifier in the legacy format
+func (m *Module) parseAttributes(attributes []string) error {
+	for _, attr := range attributes {
+		key, value, ok := strings.Cut(attr, "=")
+		if !ok || value == "" {
+			return fmt.Errorf("invalid attribute %q, expected key=value", attr)
+		}
+
+		var err error
+		switch key {
+		case "uid":
+			m.UID = append(m.UID, strings.Split(value, ",")...)
+		case "gid":
+			m.GID = append(m.GID, strings.Split(value, ",")...)
+		case "types":
+			m.MessageTypes = append(m.MessageTypes, strings.Split(value, ",")...)
+		case "rate":
+			m.RateLimit.Rate, err = strconv.ParseFloat(value, 64)
+		case "burst":
+			m.RateLimit.Burst, err = strconv.Atoi(value)
+		case "cert":
+			m.Cert = value
+		default:
+			return fmt.Errorf("unknown attribute %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// moduleFields are the keys of a module definition in the YAML format
+var moduleFields = map[string]bool{
+	"name": true, "identifier": true, "description": true, "message_types": true, "uid": true, "gid": true, "rate_limit": true, "cert": true,
+}
+
+// parseYAMLModules parses the modules listed under "modules" in a YAML document
+func parseYAMLModules(data []byte) ([]lineModule, []error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []error{err} // The errors of the YAML parser have the line number already
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{&ModuleError{Line: root.Line, Err: fmt.Errorf("%w: expected a mapping with the modules", ErrInvalidModule)}}
+	}
+
+	var list *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "modules" {
+			list = root.Content[i+1]
+		}
+	}
+	if list == nil {
+		return nil, nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, []error{&ModuleError{Line: list.Line, Err: fmt.Errorf("%w: expected a list of modules", ErrInvalidModule)}}
+	}
+
+	var (
+		modules []lineModule
+		errs    []error
+	)
+	for _, item := range list.Content {
+		if item.Kind == yaml.MappingNode {
+			for i := 0; i < len(item.Content); i += 2 {
+				if key := item.Content[i]; !moduleFields[key.Value] {
+					errs = append(errs, &ModuleError{Line: key.Line, Err: fmt.Errorf("%w: unknown field %q", ErrInvalidModule, key.Value)})
+				}
+			}
+		}
+
+		var m Module
+		if err := item.Decode(&m); err != nil {
+			errs = append(errs, &ModuleError{Line: item.Line, Err: fmt.Errorf("%w: %v", ErrInvalidModule, err)})
+			continue
+		}
+		modules = append(modules, lineModule{item.Line, m})
+	}
+	return modules, errs
+}
+
+// parseJSONModules parses the modules listed under "modules" in a JSON object
+func parseJSONModules(data []byte) ([]lineModule, []error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	syntaxError := func(err error) []error {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			return []error{&ModuleError{Line: lineAt(data, int(se.Offset)), Err: fmt.Errorf("%w: %v", ErrInvalidModule, err)}}
+		}
+		return []error{fmt.Errorf("%w: %v", ErrInvalidModule, err)}
+	}
+
+	if t, err := dec.Token(); err != nil {
+		return nil, syntaxError(err)
+	} else if t != json.Delim('{') {
+		return nil, []error{&ModuleError{Line: 1, Err: fmt.Errorf("%w: expected an object with the modules", ErrInvalidModule)}}
+	}
+
+	var (
+		modules []lineModule
+		errs    []error
+	)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		if key != "modules" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, syntaxError(err)
+			}
+			continue
+		}
+
+		if t, err := dec.Token(); err != nil {
+			return nil, syntaxError(err)
+		} else if t != json.Delim('[') {
+			return nil, []error{&ModuleError{Line: lineAt(data, int(dec.InputOffset())), Err: fmt.Errorf("%w: expected a list of modules", ErrInvalidModule)}}
+		}
+		for dec.More() {
+			line := lineAt(data, int(dec.InputOffset()))
+
+			var m Module
+			if err := dec.Decode(&m); err != nil {
+				var se *json.SyntaxError
+				if errors.As(err, &se) {
+					return nil, syntaxError(err)
+				}
+				errs = append(errs, &ModuleError{Line: line, Err: fmt.Errorf("%w: %v", ErrInvalidModule, err)})
+				continue
+			}
+			modules = append(modules, lineModule{line, m})
+		}
+		if _, err := dec.Token(); err != nil { // ]
+			return nil, syntaxError(err)
+		}
+	}
+	return modules, errs
+}
+
+// lineAt returns the line of the first value at or after the offset, skipping whitespace and separators
+func lineAt(data []byte, offset int) int {
+	for offset < len(data) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(data[:min(offset, len(data))], []byte("\n")) + 1
+}
+
+// limiter is a token bucket enforcing the rate limit of a module on a connection, or on the datagram socket.
+// It is only used by the read loop of the connection or of the socket.
+type limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns the limiter of the rate limit, nil if the rate is unlimited
+func newLimiter(r RateLimit) *limiter {
+	if r.Rate <= 0 {
+		return nil
+	}
+	burst := float64(r.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(r.Rate)
+	}
+	return &limiter{rate: r.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// allow takes a token, and returns false if there is none left. A nil limiter allows everything.
+func (l *limiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,166 @@
+package ipcserver_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// writeModules writes a module definitions file and returns its path
+func writeModules(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadModulesFormats tests that the same modules load from every format
+func TestLoadModulesFormats(t *testing.T) {
+	files := map[string]string{
+		"modules.txt": "# modules\nfmt1 FMT1 uid=1234 types=msg,publish rate=10 :: first module\nfmt2 FMT2\n",
+		"modules.yaml": `modules:
+  - name: fmt1
+    identifier: FMT1
+    description: first module
+    message_types: [msg, publish]
+    uid: 1234
+    rate_limit:
+      rate: 10
+  - name: fmt2
+    identifier: FMT2
+`,
+		"modules.json": `{"modules": [
+	{"name": "fmt1", "identifier": "FMT1", "description": "first module", "message_types": ["msg", "publish"], "uid": [1234], "rate_limit": {"rate": 10}},
+	{"name": "fmt2", "identifier": "FMT2"}
+]}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			delete(ipcserver.MODULEIDENTIFIERS, "fmt1")
+			delete(ipcserver.MODULEIDENTIFIERS, "fmt2")
+
+			if err := ipcserver.LoadModules(writeModules(t, name, content)); err != nil {
+				t.Fatal(err)
+			}
+			if id := string(ipcserver.MODULEIDENTIFIERS["fmt1"]); id != "FMT1" {
+				t.Errorf("Expected identifier FMT1, but got %q", id)
+			}
+			if id := string(ipcserver.MODULEIDENTIFIERS["fmt2"]); id != "FMT2" {
+				t.Errorf("Expected identifier FMT2, but got %q", id)
+			}
+			if a := ipcserver.MODULECREDENTIALS["fmt1"]; !slices.Equal(a.UIDs, []uint32{1234}) {
+				t.Errorf("Expected uid 1234, but got %+v", a)
+			}
+		})
+	}
+}
+
+// TestLoadModulesErrors tests that invalid definitions are reported with their line, and nothing is loaded
+func TestLoadModulesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected error
+		line     string
+	}{
+		{"missing identifier", "modules.txt", "ok01 OK01\nlonely\n", ipcserver.ErrInvalidModule, ":2:"},
+		{"long identifier", "modules.txt", "ok02 OK02\n\n# comment\nlong TOOLONG\n", ipcserver.ErrInvalidModule, ":4:"},
+		{"duplicate identifier", "modules.txt", "ok03 OK03\ndup1 OK03\n", ipcserver.ErrDuplicateModule, ":2:"},
+		{"duplicate name", "modules.txt", "ok04 OK04\nok04 DUP2\n", ipcserver.ErrDuplicateModule, ":2:"},
+		{"unknown attribute", "modules.txt", "ok05 OK05 color=red\n", ipcserver.ErrInvalidModule, ":1:"},
+		{"unknown message type", "modules.yaml", "modules:\n  - name: ok06\n    identifier: OK06\n  - name: bad6\n    identifier: BAD6\n    message_types: [shout]\n", ipcserver.ErrInvalidModule, ":4:"},
+		{"unknown field", "modules.yaml", "modules:\n  - name: ok07\n    identifier: OK07\n    identifer: TYPO\n", ipcserver.ErrInvalidModule, ":4:"},
+		{"json duplicate", "modules.json", "{\"modules\": [\n  {\"name\": \"ok08\", \"identifier\": \"OK08\"},\n  {\"name\": \"ok08\", \"identifier\": \"DUP8\"}\n]}", ipcserver.ErrDuplicateModule, ":3:"},
+		{"json unknown field", "modules.json", "{\"modules\": [\n  {\"name\": \"ok09\", \"identifier\": \"OK09\", \"color\": \"red\"}\n]}", ipcserver.ErrInvalidModule, ":2:"},
+		{"missing file", "", "", os.ErrNotExist, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "missing.txt")
+			if tt.file != "" {
+				path = writeModules(t, tt.file, tt.content)
+			}
+
+			err := ipcserver.LoadModules(path)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("Expected %v, but got %v", tt.expected, err)
+			}
+			if !strings.Contains(err.Error(), tt.line) {
+				t.Errorf("Expected the line %s in the error, but got %v", tt.line, err)
+			}
+			for name := range ipcserver.MODULEIDENTIFIERS {
+				if strings.HasPrefix(name, "ok") {
+					t.Errorf("Expected no module to be loaded, but got %s", name)
+				}
+			}
+		})
+	}
+}
+
+// TestModuleRestrictions tests that the message types and the rate limit of a module definition are enforced
+func TestModuleRestrictions(t *testing.T) {
+	err := ipcserver.RegisterModule(ipcserver.Module{
+		Name:         "limited",
+		Identifier:   "LIMT",
+		MessageTypes: []string{"msg"},
+		RateLimit:    ipcserver.RateLimit{Rate: 0.001, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+	c := ipcclient.NewIPCClient("limited", "LIMT", "test", ipcclient.WithSocketPath(path))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for range 2 {
+		if _, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+			t.Fatalf("Expected the requests within the burst to succeed, but got %v", err)
+		}
+	}
+	if err := c.Publish("events", ipc.DATA_TEXT, []byte("hello")); !errors.Is(err, ipc.ErrUnauthorized) {
+		t.Errorf("Expected publishing to be forbidden, but got %v", err)
+	}
+	if _, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); !errors.Is(err, ipc.ErrRateLimited) {
+		t.Errorf("Expected the request to be rate limited, but got %v", err)
+	}
+}
+
+// TestModuleIdentifierLength tests that module definitions and AddModule accept and refuse the same identifiers
+func TestModuleIdentifierLength(t *testing.T) {
+	if err := ipcserver.LoadModules(writeModules(t, "modules.txt", "short SHO\n")); err != nil {
+		t.Errorf("Expected a short identifier to be accepted, but got %v", err)
+	}
+	if id := ipcserver.MODULEIDENTIFIERS["short"]; string(id) != "SHO" {
+		t.Errorf("Expected identifier SHO, but got %q", id)
+	}
+	if err := ipcserver.RegisterModule(ipcserver.Module{Name: "toolong", Identifier: "TOOLONG"}); !errors.Is(err, ipcserver.ErrInvalidModule) {
+		t.Errorf("Expected a long identifier to be refused, but got %v", err)
+	}
+
+	ipcserver.AddModule("added short", []byte("ASH"))
+	if id := ipcserver.MODULEIDENTIFIERS["added short"]; string(id) != "ASH" {
+		t.Errorf("Expected identifier ASH, but got %q", id)
+	}
+	ipcserver.AddModule("added long", []byte("ADDLONG"))
+	if id, ok := ipcserver.MODULEIDENTIFIERS["added long"]; ok {
+		t.Errorf("Expected a long identifier to be refused, but got %q", id)
+	}
+}
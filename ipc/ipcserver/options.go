@@ -0,0 +1,161 @@
+package ipcserver
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+const (
+	DefaultMaxConnections = 64  // Default limit of concurrently connected modules
+	DefaultMaxInflight    = 256 // Default limit of requests being handled at the same time
+
+	DefaultHeartbeatMissed = 3 // Default number of pongs a peer may miss in a row before it is considered dead
+)
+
+// Option configures an IPCServer
+//
+// Example:
+//
+//	server := ipcserver.NewIPCServer("servername", "SRVR", ipcserver.WithMaxConnections(8))
+type Option func(*IPCServer)
+
+// WithSocketPath sets the path of the UNIX domain socket, instead of the default path derived from the server name
+func WithSocketPath(path string) Option {
+	return func(s *IPCServer) {
+		s.path = path
+	}
+}
+
+// WithTCP listens on the TCP address instead of a UNIX domain socket, for modules that can't share a socket file,
+// such as modules in other containers. An empty address is ipc.Address, and a port of 0 is picked by the system (see Addr).
+// The protocol and the handshake are the same, but there are no peer credentials: modules restricted to users or
+// groups can't connect over TCP. Use WithTLS to authenticate the clients with certificates instead.
+func WithTCP(addr string) Option {
+	return func(s *IPCServer) {
+		if addr == "" {
+			addr = ipc.Address
+		}
+		s.addr = addr
+	}
+}
+
+// WithTLS serves the TCP connections over TLS with the configuration, see ipc.ServerTLSConfig.
+// With ClientAuth set to tls.RequireAndVerifyClientCert, the clients must present a certificate (mutual TLS),
+// which the handlers find in the ConnInfo of the request. It has no effect without WithTCP.
+func WithTLS(config *tls.Config) Option {
+	return func(s *IPCServer) {
+		s.tlsConfig = config
+	}
+}
+
+// WithMaxConnections limits the number of connections served at the same time.
+// Connections above the limit wait in the listen backlog until a slot is free.
+// A limit of 0 or less means no limit.
+func WithMaxConnections(n int) Option {
+	return func(s *IPCServer) {
+		s.maxConns = n
+	}
+}
+
+// WithMaxInflight limits the number of requests being handled at the same time, across all connections.
+// A connection stops reading new requests while the limit is reached.
+// A limit of 0 or less means no limit.
+func WithMaxInflight(n int) Option {
+	return func(s *IPCServer) {
+		s.maxInflight = n
+	}
+}
+
+// WithCodec sets the wire format of the connections. The clients must use the same codec.
+// The default is ipc.GobCodec.
+func WithCodec(codec ipc.Codec) Option {
+	return func(s *IPCServer) {
+		s.codec = codec
+	}
+}
+
+// WithHeartbeat pings the connected modules at the given interval, and closes the connections of the modules
+// that miss maxMissed pongs in a row. A maxMissed of 0 or less uses DefaultHeartbeatMissed.
+// Heartbeats are disabled by default. The health of the connections is available from Health.
+func WithHeartbeat(interval time.Duration, maxMissed int) Option {
+	return func(s *IPCServer) {
+		s.heartbeat = interval
+		if maxMissed > 0 {
+			s.maxMissed = maxMissed
+		} else {
+			s.maxMissed = DefaultHeartbeatMissed
+		}
+	}
+}
+
+// WithMiddleware replaces the default middleware of the server (see DefaultMiddleware).
+// More middleware can be appended with Use.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(s *IPCServer) {
+		s.middleware = middleware
+	}
+}
+
+// WithLogger sets the logger of the server, instead of the default logger of the ipc packages (see ipc.SetLogger)
+func WithLogger(l *slog.Logger) Option {
+	return func(s *IPCServer) {
+		s.log = l
+	}
+}
+
+// WithPayloadLogging logs the payloads of the messages at debug level, as returned by the redactor.
+// A nil redactor logs the payloads as is. Payloads are not logged by default.
+func WithPayloadLogging(redact ipc.Redactor) Option {
+	return func(s *IPCServer) {
+		s.logPayloads = true
+		s.redact = redact
+	}
+}
+
+// WithModuleFile loads the modules allowed to connect from the module definitions file when the server starts,
+// see LoadModules. The file is loaded again on SIGHUP, and when it changes on disk, checked at the given interval.
+// An interval of 0 only reloads the file on SIGHUP. See ReloadModules.
+func WithModuleFile(path string, interval time.Duration) Option {
+	return func(s *IPCServer) {
+		s.modules = &moduleFile{path: path, interval: interval}
+	}
+}
+
+// WithSocketMode sets the mode of the socket file. The default is DefaultSocketMode, or 0660 with a socket group.
+// Only processes with write permission on the socket can connect to it.
+func WithSocketMode(mode os.FileMode) Option {
+	return func(s *IPCServer) {
+		s.socketMode = mode
+	}
+}
+
+// WithSocketGroup sets the group of the socket file, by name or id, to let the modules of the group connect.
+// If the server creates the socket directory, the group can search it as well (mode 0710).
+func WithSocketGroup(group string) Option {
+	return func(s *IPCServer) {
+		s.socketGroup = group
+	}
+}
+
+// WithDatagramSocket enables datagram mode: the server also receives requests on a unixgram socket at the path,
+// for fire-and-forget messages that need neither a connection nor a reply (see ipcclient.IPCClient.SendDatagram).
+// The socket is created like the stream socket, with the same mode and group.
+func WithDatagramSocket(path string) Option {
+	return func(s *IPCServer) {
+		s.dgramPath = path
+	}
+}
+
+// WithJSONRPCSocket enables JSON-RPC compatibility mode: the server also accepts newline-delimited JSON-RPC 2.0
+// on a second stream socket at the path, for tools that can't speak the wire format, such as socat or nc -U.
+// The calls are passed to the same handler as the requests of the other connections.
+// The socket is created like the stream socket, with the same mode and group.
+func WithJSONRPCSocket(path string) Option {
+	return func(s *IPCServer) {
+		s.jsonrpcPath = path
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package ipcserver
+
+import "io/fs"
+
+// fileOwner is only implemented on Unix, the mode of the directory is the only check elsewhere
+func fileOwner(info fs.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,121 @@
+package ipcserver
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrPeerCredentialsUnsupported is returned when the peer credentials can't be read on the platform or transport
+var ErrPeerCredentialsUnsupported = errors.New("peer credentials are not supported")
+
+/* MODULECREDENTIALS
+ * Allowlists of the system users and groups that may connect as a module, by module name.
+ */
+var MODULECREDENTIALS = map[string]Allowlist{}
+
+// Credentials of the process on the other end of a connection, as reported by the kernel (SO_PEERCRED)
+type Credentials struct {
+	UID uint32 // User ID of the peer process
+	GID uint32 // Group ID of the peer process
+	PID int32  // Process ID of the peer process
+}
+
+// Allowlist restricts which system users and groups may connect as a module.
+// A peer is allowed if its user is in UIDs (when set) and its group is in GIDs (when set).
+type Allowlist struct {
+	UIDs []uint32
+	GIDs []uint32
+}
+
+// Empty returns true if the allowlist doesn't restrict anything
+func (a Allowlist) Empty() bool {
+	return len(a.UIDs) == 0 && len(a.GIDs) == 0
+}
+
+// Allows checks the peer credentials against the allowlist
+func (a Allowlist) Allows(cred *Credentials) bool {
+	if a.Empty() {
+		return true
+	}
+	if cred == nil {
+		return false
+	}
+	if len(a.UIDs) > 0 && !slices.Contains(a.UIDs, cred.UID) {
+		return false
+	}
+	if len(a.GIDs) > 0 && !slices.Contains(a.GIDs, cred.GID) {
+		return false
+	}
+	return true
+}
+
+// ParseAllowlist parses the credential attributes of a module definition.
+// Users and groups are given by name or by id, and several can be separated by commas.
+//
+// Example:
+//
+//	ParseAllowlist([]string{"uid=sigma", "gid=ipc,1001"})
+func ParseAllowlist(attributes []string) (Allowlist, error) {
+	var a Allowlist
+	for _, attr := range attributes {
+		key, values, ok := strings.Cut(attr, "=")
+		if !ok || values == "" {
+			return a, fmt.Errorf("invalid attribute %q, expected key=value", attr)
+		}
+		for _, value := range strings.Split(values, ",") {
+			switch key {
+			case "uid":
+				id, err := lookupId(value, user.Lookup, func(u *user.User) string { return u.Uid })
+				if err != nil {
+					return a, fmt.Errorf("uid %q: %w", value, err)
+				}
+				a.UIDs = append(a.UIDs, id)
+			case "gid":
+				id, err := lookupId(value, user.LookupGroup, func(g *user.Group) string { return g.Gid })
+				if err != nil {
+					return a, fmt.Errorf("gid %q: %w", value, err)
+				}
+				a.GIDs = append(a.GIDs, id)
+			default:
+				return a, fmt.Errorf("unknown attribute %q", key)
+			}
+		}
+	}
+	return a, nil
+}
+
+// lookupId returns the numeric id, or the id of the named user or group
+func lookupId[T any](value string, lookup func(string) (T, error), id func(T) string) (uint32, error) {
+	if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	found, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(id(found), 10, 32)
+	return uint32(n), err
+}
+
+// SetAllowlist restricts which system users and groups may connect as the named module
+func SetAllowlist(module string, a Allowlist) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	if a.Empty() {
+		delete(MODULECREDENTIALS, module)
+		return
+	}
+	MODULECREDENTIALS[module] = a
+}
+
+// moduleAllowlist returns the allowlist of the named module
+func moduleAllowlist(module string) Allowlist {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	return MODULECREDENTIALS[module]
+}
@@ -0,0 +1,38 @@
+package ipcserver
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials reads the credentials of the peer process of a UNIX domain socket connection
+func peerCredentials(c net.Conn) (*Credentials, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil, ErrPeerCredentialsUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	return &Credentials{
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+		PID: ucred.Pid,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package ipcserver_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestPeerCredentials tests that the credentials of the client process are available to the handlers
+func TestPeerCredentials(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			info, ok := ipcserver.ConnInfoFromContext(ctx)
+			if !ok || info.Peer == nil {
+				return nil, fmt.Errorf("no peer credentials")
+			}
+			peer := fmt.Sprintf("%d %d %d", info.Peer.UID, info.Peer.GID, info.Peer.PID)
+			return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte(peer)), nil
+		})
+	})
+	c := connect(t, path, "CRED")
+
+	expected := fmt.Sprintf("%d %d %d", os.Getuid(), os.Getgid(), os.Getpid())
+	res, err := c.SendIPCMessage(c.CreateReq("who am i", ipc.MSG_MSG, ipc.DATA_TEXT))
+	if err != nil || res.StringData != expected {
+		t.Errorf("Expected the peer credentials %q, but got %q, %v", expected, res.StringData, err)
+	}
+}
+
+// TestPeerCredentialsAllowlist tests that modules are only accepted from the users in their allowlist
+func TestPeerCredentialsAllowlist(t *testing.T) {
+	path := startServer(t, nil)
+
+	tests := []struct {
+		identifier string
+		uid        int
+		allowed    bool
+	}{
+		{"ALOW", os.Getuid(), true},
+		{"DENY", os.Getuid() + 1, false},
+	}
+
+	for _, test := range tests {
+		allowlist, err := ipcserver.ParseAllowlist([]string{fmt.Sprintf("uid=%d", test.uid)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipcserver.AddModule("module "+test.identifier, []byte(test.identifier))
+		ipcserver.SetAllowlist("module "+test.identifier, allowlist)
+
+		c := &ipcclient.IPCClient{Name: test.identifier, Sock: path}
+		copy(c.Identifier[:], test.identifier)
+		err = c.Connect()
+		if err == nil {
+			c.Close()
+		}
+		if test.allowed && err != nil {
+			t.Errorf("Expected uid %d to be allowed, but got %v", test.uid, err)
+		}
+		if !test.allowed && err == nil {
+			t.Errorf("Expected uid %d to be refused", test.uid)
+		}
+	}
+}
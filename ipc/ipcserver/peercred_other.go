@@ -0,0 +1,10 @@
+//go:build !linux
+
+package ipcserver
+
+import "net"
+
+// peerCredentials is only implemented on Linux
+func peerCredentials(c net.Conn) (*Credentials, error) {
+	return nil, ErrPeerCredentialsUnsupported
+}
@@ -0,0 +1,71 @@
+package ipcserver_test
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestParseAllowlist tests parsing the credential attributes of a module definition
+func TestParseAllowlist(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("no current user: ", err)
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+
+	a, err := ipcserver.ParseAllowlist([]string{"uid=" + current.Username + ",4242", "gid=17"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(a.UIDs, []uint32{uint32(uid), 4242}) {
+		t.Errorf("Expected uids [%d 4242], but got %v", uid, a.UIDs)
+	}
+	if !slices.Equal(a.GIDs, []uint32{17}) {
+		t.Errorf("Expected gids [17], but got %v", a.GIDs)
+	}
+
+	if !a.Allows(&ipcserver.Credentials{UID: 4242, GID: 17}) {
+		t.Errorf("Expected uid 4242 gid 17 to be allowed")
+	}
+	if a.Allows(&ipcserver.Credentials{UID: 4242, GID: 18}) {
+		t.Errorf("Expected gid 18 to be refused")
+	}
+	if a.Allows(nil) {
+		t.Errorf("Expected missing credentials to be refused")
+	}
+
+	for _, invalid := range []string{"uid", "uid=", "pid=1", "uid=no-such-user-here"} {
+		if _, err := ipcserver.ParseAllowlist([]string{invalid}); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+// TestLoadModulesAllowlist tests that LoadModules reads the credential attributes
+func TestLoadModulesAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modules.txt")
+	config := "# comment\nsigma\tSIGM  uid=1234 gid=5678 :: sigma rules module\nplain PLAN :: no restrictions\n"
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ipcserver.LoadModules(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := string(ipcserver.MODULEIDENTIFIERS["sigma"]); id != "SIGM" {
+		t.Errorf("Expected identifier SIGM, but got %q", id)
+	}
+	if a := ipcserver.MODULECREDENTIALS["sigma"]; !slices.Equal(a.UIDs, []uint32{1234}) || !slices.Equal(a.GIDs, []uint32{5678}) {
+		t.Errorf("Expected uid 1234 and gid 5678, but got %+v", a)
+	}
+	if _, ok := ipcserver.MODULECREDENTIALS["plain"]; ok {
+		t.Errorf("Expected no allowlist for the plain module")
+	}
+}
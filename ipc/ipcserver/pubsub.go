@@ -0,0 +1,152 @@
+package ipcserver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// eventQueueSize is how many published events a connection holds before new ones are dropped,
+// so a subscriber that doesn't read doesn't hold up the publishers
+const eventQueueSize = 64
+
+// topics is the registry of the subscribed connections, by topic
+type topics struct {
+	mu   sync.RWMutex
+	subs map[string]map[*connection]struct{}
+}
+
+func newTopics() *topics {
+	return &topics{subs: map[string]map[*connection]struct{}{}}
+}
+
+func (t *topics) subscribe(topic string, cn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.subs[topic] == nil {
+		t.subs[topic] = map[*connection]struct{}{}
+	}
+	t.subs[topic][cn] = struct{}{}
+}
+
+func (t *topics) unsubscribe(topic string, cn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.subs[topic], cn)
+	if len(t.subs[topic]) == 0 {
+		delete(t.subs, topic)
+	}
+}
+
+// unsubscribeAll removes the connection from every topic, when it is closed
+func (t *topics) unsubscribeAll(cn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for topic, conns := range t.subs {
+		delete(conns, cn)
+		if len(conns) == 0 {
+			delete(t.subs, topic)
+		}
+	}
+}
+
+// subscribers returns the connections subscribed to the topic
+func (t *topics) subscribers(topic string) []*connection {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	conns := make([]*connection, 0, len(t.subs[topic]))
+	for cn := range t.subs[topic] {
+		conns = append(conns, cn)
+	}
+	return conns
+}
+
+// Publish sends the payload to every connection subscribed to the topic, as a MSG_PUBLISH message from the server.
+// It returns the number of subscribers the event was queued for, events for subscribers that fall behind are dropped.
+func (s *IPCServer) Publish(topic string, dataType ipc.DataType, payload []byte) (int, error) {
+	return s.publish(s.id(), topic, dataType, payload)
+}
+
+// publish fans the event out to the subscribers of the topic, without waiting for them to be sent.
+// Subscribers whose queue is full are skipped, like the client drops events for the subscriptions that fall behind.
+func (s *IPCServer) publish(source [4]byte, topic string, dataType ipc.DataType, payload []byte) (int, error) {
+	data, err := ipc.PackTopic(topic, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := newMessage(source, ipc.MSG_PUBLISH, dataType, data)
+	msg.MessageSignature = []byte(s.identifier)
+
+	delivered := 0
+	for _, cn := range s.topics.subscribers(topic) {
+		select {
+		case cn.events <- msg:
+			delivered++
+		default:
+			s.logger().Warn("subscriber queue full, dropping event", "topic", topic, "module", cn.name())
+		}
+	}
+	return delivered, nil
+}
+
+// sendEvents sends the queued events to the subscriber until the connection is closed.
+// A subscriber that doesn't take an event within ipc.Timeout is disconnected, its connection is closed by SendContext.
+func (cn *connection) sendEvents() {
+	for {
+		var msg *ipc.IPCRequest
+		select {
+		case <-cn.ctx.Done():
+			return
+		case msg = <-cn.events:
+		}
+
+		ctx, cancel := context.WithTimeout(cn.ctx, ipc.Timeout)
+		err := cn.stream.SendContext(ctx, msg)
+		cancel()
+		if err != nil {
+			if cn.ctx.Err() == nil {
+				cn.log.Warn("failed to send event, closing the connection", "err", err)
+				cn.c.Close() // Unblocks the read loop
+			}
+			return
+		}
+	}
+}
+
+// pubsub handles the MSG_SUBSCRIBE, MSG_UNSUBSCRIBE and MSG_PUBLISH messages of the connection
+func (cn *connection) pubsub(req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	s := cn.server
+
+	switch req.Header.MessageType {
+	case ipc.MSG_SUBSCRIBE, ipc.MSG_UNSUBSCRIBE:
+		topic := string(req.Message.Data)
+		if err := ipc.ValidateTopic(topic); err != nil {
+			return nil, err
+		}
+		if req.Header.MessageType == ipc.MSG_SUBSCRIBE {
+			cn.eventsOnce.Do(func() { go cn.sendEvents() })
+			s.topics.subscribe(topic, cn)
+			cn.log.Debug("subscribed", "topic", topic)
+		} else {
+			s.topics.unsubscribe(topic, cn)
+			cn.log.Debug("unsubscribed", "topic", topic)
+		}
+
+	case ipc.MSG_PUBLISH:
+		topic, payload, err := ipc.UnpackTopic(req.Message.Data)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.publish(req.Header.Identifier, topic, req.Message.Datatype, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil // Acknowledged
+}
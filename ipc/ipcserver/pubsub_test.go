@@ -0,0 +1,158 @@
+package ipcserver_test
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// receive waits for the next event of the subscription
+func receive(t *testing.T, sub *ipcclient.Subscription) ipc.Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-sub.C:
+		if !ok {
+			t.Fatalf("Subscription to %s closed", sub.Topic)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for an event on %s", sub.Topic)
+	}
+	return ipc.Event{}
+}
+
+// TestPubSub tests that published events are fanned out to the subscribed modules only
+func TestPubSub(t *testing.T) {
+	path := startServer(t, nil)
+	a, b, publisher := connect(t, path, "SUBA"), connect(t, path, "SUBB"), connect(t, path, "PUBL")
+
+	subA, err := a.Subscribe("threat_intel.inserted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subB, err := b.Subscribe("threat_intel.inserted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := b.Subscribe("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := publisher.Publish("threat_intel.inserted", ipc.DATA_JSON, []byte(`{"row_id": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []*ipcclient.Subscription{subA, subB} {
+		event := receive(t, sub)
+		if event.Topic != "threat_intel.inserted" || event.Source != [4]byte{'P', 'U', 'B', 'L'} {
+			t.Errorf("Expected an event on threat_intel.inserted from PUBL, but got %q from %q", event.Topic, event.Source[:])
+		}
+		if event.Message.Datatype != ipc.DATA_JSON || event.Message.StringData != `{"row_id": 1}` {
+			t.Errorf("Expected the published JSON, but got %v %q", event.Message.Datatype, event.Message.StringData)
+		}
+	}
+
+	if err := subB.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-subB.C; ok {
+		t.Errorf("Expected the channel to be closed after Unsubscribe")
+	}
+
+	if err := publisher.Publish("threat_intel.inserted", ipc.DATA_JSON, []byte(`{"row_id": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(t, subA); event.Message.StringData != `{"row_id": 2}` {
+		t.Errorf("Expected the second event, but got %q", event.Message.StringData)
+	}
+
+	select {
+	case event := <-other.C:
+		t.Errorf("Expected no event on the other topic, but got %+v", event)
+	default:
+	}
+}
+
+// TestServerPublish tests that the server can publish events itself
+func TestServerPublish(t *testing.T) {
+	server, path := newServer(t, nil)
+	c := connect(t, path, "SRVP")
+
+	sub, err := c.Subscribe("server.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := server.Publish("server.events", ipc.DATA_TEXT, []byte("hello"))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected the event to be delivered to 1 subscriber, but got %d, %v", n, err)
+	}
+	if event := receive(t, sub); event.Source != [4]byte{'T', 'E', 'S', 'T'} || event.Message.StringData != "hello" {
+		t.Errorf("Expected hello from the server, but got %q from %q", event.Message.StringData, event.Source[:])
+	}
+
+	if _, err := server.Publish("", ipc.DATA_TEXT, nil); err == nil {
+		t.Errorf("Expected an error for an empty topic")
+	}
+}
+
+// TestSlowSubscriber tests that a subscriber that doesn't read doesn't block the publishers,
+// and that it is disconnected once it falls behind for ipc.Timeout
+func TestSlowSubscriber(t *testing.T) {
+	server, path := newServer(t, nil)
+	ipcserver.AddModule("module SLOW", []byte("SLOW"))
+
+	slow := dial(t, path)
+	header := ipc.IPCHeader{Identifier: [4]byte{'S', 'L', 'O', 'W'}, MessageType: ipc.MSG_CONN}
+	version := []byte(strconv.Itoa(ipc.PROTOCOL_VERSION))
+	if res := roundTrip(t, slow, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_INT, Data: version}}); res.Header.MessageType != ipc.MSG_CONNACK {
+		t.Fatalf("Expected MSG_CONNACK, but got message type 0x%02x: %s", res.Header.MessageType, res.Message.StringData)
+	}
+	header.MessageType = ipc.MSG_SUBSCRIBE
+	if res := roundTrip(t, slow, ipc.IPCRequest{Header: header, Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte("slow.events")}}); res.Header.MessageType == ipc.MSG_ERROR {
+		t.Fatalf("Failed to subscribe: %s", res.Message.StringData)
+	}
+
+	sub, err := connect(t, path, "FAST").Subscribe("slow.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow subscriber never reads, so its socket fills up after a few events
+	published := make(chan error, 1)
+	go func() {
+		payload := bytes.Repeat([]byte("x"), 64*1024)
+		for range 256 {
+			if _, err := server.Publish("slow.events", ipc.DATA_BIN, payload); err != nil {
+				published <- err
+				return
+			}
+		}
+		published <- nil
+	}()
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publishing was blocked by the slow subscriber")
+	}
+	if event := receive(t, sub); len(event.Message.Data) != 64*1024 {
+		t.Errorf("Expected the other subscriber to receive the events, but got %d bytes", len(event.Message.Data))
+	}
+
+	time.Sleep(ipc.Timeout + 500*time.Millisecond)
+	conn := slow.Conn()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Errorf("Expected the slow subscriber to be disconnected, but got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+var fastBackoff = ipcclient.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Jitter: 0.2}
+
+// TestReconnect tests that the client reconnects when the server restarts, and restores its subscriptions
+func TestReconnect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	register := func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	}
+	server, _ := serveAt(t, path, register)
+
+	reconnected := make(chan struct{}, 1)
+	ipcserver.AddModule("module RECO", []byte("RECO"))
+	c := ipcclient.NewIPCClient("client RECO", "RECO", "test", ipcclient.WithSocketPath(path),
+		ipcclient.WithReconnect(fastBackoff),
+		ipcclient.WithOnReconnect(func(c *ipcclient.IPCClient) error {
+			if _, err := c.SendIPCMessage(c.CreateReq("hook", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+				return err
+			}
+			reconnected <- struct{}{}
+			return nil
+		}))
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	sub, err := c.Subscribe("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := connect(t, path, "PLAN")
+	plainSub, err := plain.Subscribe("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down the server: %v", err)
+	}
+	select {
+	case _, ok := <-plainSub.C:
+		if ok {
+			t.Errorf("Expected no event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the subscription of the client without reconnection to be closed")
+	}
+
+	serveAt(t, path, register)
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the client to reconnect")
+	}
+
+	publisher := connect(t, path, "RPUB")
+	if err := publisher.Publish("events", ipc.DATA_TEXT, []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(t, sub); event.Message.StringData != "after" {
+		t.Errorf("Expected the event published after the reconnection, but got %q", event.Message.StringData)
+	}
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestReconnectHookFails tests that the client keeps reconnecting when the OnReconnect hook fails
+func TestReconnectHookFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	register := func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	}
+	server, _ := serveAt(t, path, register)
+
+	var calls atomic.Int32
+	reconnected := make(chan struct{}, 1)
+	ipcserver.AddModule("module HOOK", []byte("HOOK"))
+	c := ipcclient.NewIPCClient("client HOOK", "HOOK", "test", ipcclient.WithSocketPath(path),
+		ipcclient.WithReconnect(fastBackoff),
+		ipcclient.WithOnReconnect(func(c *ipcclient.IPCClient) error {
+			if calls.Add(1) == 1 {
+				return errors.New("not ready yet")
+			}
+			reconnected <- struct{}{}
+			return nil
+		}))
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down the server: %v", err)
+	}
+	serveAt(t, path, register)
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the client to reconnect, the hook ran %d times", calls.Load())
+	}
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo after the failed hook, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestReconnectRestoresFirst tests that the requests only use the new connection once the subscriptions are restored,
+// so an event published after a successful request is never missed
+func TestReconnectRestoresFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	var subscribed atomic.Int32
+	register := func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+		s.Use(func(next ipcserver.Handler) ipcserver.Handler {
+			return ipcserver.HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+				if req.Header.MessageType == ipc.MSG_SUBSCRIBE && subscribed.Add(1) > 1 {
+					time.Sleep(500 * time.Millisecond) // Restoring the subscription takes a while
+				}
+				return next.ServeIPC(ctx, req)
+			})
+		})
+	}
+	server, _ := serveAt(t, path, register)
+
+	c := connect(t, path, "RSTR", ipcclient.WithReconnect(fastBackoff))
+	sub, err := c.Subscribe("restore.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down the server: %v", err)
+	}
+	serveAt(t, path, register)
+	publisher := connect(t, path, "RSTP")
+
+	eventually(t, "the client to reconnect", func() bool {
+		_, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT))
+		return err == nil
+	})
+	if err := publisher.Publish("restore.events", ipc.DATA_TEXT, []byte("restored")); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(t, sub); event.Message.StringData != "restored" {
+		t.Errorf("Expected the event published after the reconnection, but got %q", event.Message.StringData)
+	}
+}
+
+// TestConnectTwice tests that Connect refuses a connected client, and connects a closed one again
+func TestConnectTwice(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+	c := connect(t, path, "TWCE")
+
+	if err := c.Connect(); !errors.Is(err, ipcclient.ErrAlreadyConnected) {
+		t.Errorf("Expected ErrAlreadyConnected, but got %v", err)
+	}
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the first connection to be kept, but got %q, %v", res.StringData, err)
+	}
+
+	c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Expected a closed client to connect again, but got %v", err)
+	}
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo after connecting again, but got %q, %v", res.StringData, err)
+	}
+}
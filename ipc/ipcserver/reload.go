@@ -0,0 +1,153 @@
+package ipcserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// ErrNoModuleFile is returned by ReloadModules when the server has no module definitions file, see WithModuleFile
+var ErrNoModuleFile = errors.New("ipcserver: no module definitions file")
+
+// moduleFile is the module definitions file of a server, and the modules last loaded from it
+type moduleFile struct {
+	path     string
+	interval time.Duration // How often the file is checked for changes, 0 disables it
+
+	loaded  map[string]*moduleDef // Modules loaded from the file, by name
+	modTime time.Time             // Modification time of the file when it was last loaded
+	size    int64                 // Size of the file when it was last loaded
+}
+
+// ReloadModules loads the module definitions file of the server again, and swaps the new set of modules in atomically.
+// Connected modules that were removed, or are no longer allowed, are disconnected.
+// If the file is invalid, the modules are left as they are and the error is returned.
+//
+// The server reloads the file by itself on SIGHUP and when it changes on disk, see WithModuleFile.
+func (s *IPCServer) ReloadModules() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.modules == nil {
+		return ErrNoModuleFile
+	}
+	return s.reloadModules()
+}
+
+// reloadModules loads the module definitions file. reloadMu must be held.
+func (s *IPCServer) reloadModules() error {
+	f := s.modules
+	log := s.logger().With("path", f.path)
+
+	if stat, err := os.Stat(f.path); err == nil {
+		f.modTime, f.size = stat.ModTime(), stat.Size() // Failed loads are not retried until the file changes again
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		log.Error("failed to reload the modules", "err", err)
+		return err
+	}
+	defs, err := parseModules(f.path, data)
+	if err != nil {
+		log.Error("failed to reload the modules, keeping the current ones", "err", err)
+		return err
+	}
+
+	loaded := make(map[string]*moduleDef, len(defs))
+	var added, changed, removed []string
+	for _, def := range defs {
+		loaded[def.Name] = def
+		if old, ok := f.loaded[def.Name]; !ok {
+			added = append(added, def.Name)
+		} else if !reflect.DeepEqual(old.Module, def.Module) {
+			changed = append(changed, def.Name)
+		}
+	}
+	for name := range f.loaded {
+		if _, ok := loaded[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	modulesMu.Lock()
+	for _, name := range removed {
+		delete(MODULEIDENTIFIERS, name)
+		delete(MODULECREDENTIALS, name)
+		delete(definitions, name)
+	}
+	for _, def := range defs {
+		addModule(def)
+	}
+	modulesMu.Unlock()
+	f.loaded = loaded
+
+	slices.Sort(added)
+	slices.Sort(changed)
+	slices.Sort(removed)
+	log.Info("modules loaded", "modules", len(defs), "added", added, "changed", changed, "removed", removed)
+
+	s.revalidate()
+	return nil
+}
+
+// revalidate disconnects the connected modules that are no longer known by their identifier, or no longer allowed
+func (s *IPCServer) revalidate() {
+	s.mu.Lock()
+	var stale []*connection
+	for cn := range s.conns {
+		if cn.info == nil {
+			continue // Checked by the handshake
+		}
+		id, ok := moduleIdentifier(cn.info.Module)
+		if !ok || id != cn.info.Identifier || !moduleAllowlist(cn.info.Module).Allows(cn.peer) || !certAllows(cn.cert, cn.info.Module) {
+			stale = append(stale, cn)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, cn := range stale {
+		cn.log.Warn("module removed, closing the connection")
+		if err := cn.disconnect("module removed"); err != nil {
+			cn.log.Debug("failed to send disconnect message", "err", err)
+		}
+		cn.close()
+	}
+}
+
+// watchModules reloads the module definitions file on SIGHUP, and when it changes on disk, until the context is done
+func (s *IPCServer) watchModules(ctx context.Context, hup <-chan os.Signal) {
+	var tick <-chan time.Time
+	if s.modules.interval > 0 {
+		ticker := time.NewTicker(s.modules.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			s.logger().Info("SIGHUP received, reloading the modules")
+			s.ReloadModules()
+		case <-tick:
+			s.reloadMu.Lock()
+			if s.modules.changed() {
+				s.reloadModules()
+			}
+			s.reloadMu.Unlock()
+		}
+	}
+}
+
+// changed returns true if the file was modified since it was last loaded
+func (f *moduleFile) changed() bool {
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		return false // Editors may replace the file, it is checked again on the next tick
+	}
+	return !stat.ModTime().Equal(f.modTime) || stat.Size() != f.size
+}
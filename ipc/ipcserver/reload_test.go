@@ -0,0 +1,132 @@
+package ipcserver_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// dialModule connects a client of a module loaded from a module definitions file
+func dialModule(t *testing.T, path string, identifier string) (*ipcclient.IPCClient, error) {
+	t.Helper()
+
+	c := ipcclient.NewIPCClient("client "+identifier, identifier, "test", ipcclient.WithSocketPath(path))
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	t.Cleanup(c.Close)
+	return c, nil
+}
+
+// TestReloadModules tests that removed modules are disconnected, and an invalid file keeps the current modules
+func TestReloadModules(t *testing.T) {
+	modules := writeModules(t, "modules.txt", "keep RLD1\ndrop RLD2\n")
+	server, path := newServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	}, ipcserver.WithModuleFile(modules, 0))
+
+	kept, err := dialModule(t, path, "RLD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := dialModule(t, path, "RLD2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(modules, []byte("keep RLD1\nnew1 RLD3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadModules(); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the removed module to be disconnected", func() bool {
+		_, err := dropped.SendIPCMessage(dropped.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT))
+		return err != nil
+	})
+	if _, err := kept.SendIPCMessage(kept.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil {
+		t.Errorf("Expected the kept module to stay connected, but got %v", err)
+	}
+	if _, err := dialModule(t, path, "RLD2"); !errors.Is(err, ipc.ErrUnknownModule) {
+		t.Errorf("Expected the removed module to be refused, but got %v", err)
+	}
+	if _, err := dialModule(t, path, "RLD3"); err != nil {
+		t.Errorf("Expected the added module to connect, but got %v", err)
+	}
+
+	if err := os.WriteFile(modules, []byte("keep RLD1\nbroken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadModules(); !errors.Is(err, ipcserver.ErrInvalidModule) {
+		t.Errorf("Expected the invalid file to be refused, but got %v", err)
+	}
+	if _, err := dialModule(t, path, "RLD3"); err != nil {
+		t.Errorf("Expected the modules to be kept after an invalid reload, but got %v", err)
+	}
+}
+
+// TestReloadModulesJSONRPC tests that a JSON-RPC client of a removed module is notified in JSON-RPC before it is disconnected
+func TestReloadModulesJSONRPC(t *testing.T) {
+	modules := writeModules(t, "modules.txt", "jsonkeep RLD4\njsondrop RLD5\n")
+	path := filepath.Join(t.TempDir(), "jsonrpc.sock")
+	server, _ := newServer(t, nil, ipcserver.WithModuleFile(modules, 0), ipcserver.WithJSONRPCSocket(path))
+	waitForSocket(t, path)
+
+	c := dialJSONRPC(t, path)
+	c.result(`{"jsonrpc": "2.0", "method": "ipc.connect", "params": {"module": "jsondrop"}, "id": 1}`)
+
+	if err := os.WriteFile(modules, []byte("jsonkeep RLD4\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadModules(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Expected a notification, but got %v", err)
+	}
+	var notification struct {
+		Version string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  string `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(line), &notification); err != nil || notification.Version != "2.0" || notification.Method != ipcserver.JSONRPC_DISCONNECT {
+		t.Errorf("Expected the %s notification, but got %q, %v", ipcserver.JSONRPC_DISCONNECT, line, err)
+	}
+	if notification.Params != "module removed" {
+		t.Errorf("Expected the reason in the params, but got %q", notification.Params)
+	}
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, but got %v", err)
+	}
+}
+
+// TestReloadOnChange tests that the module definitions file is reloaded when it changes on disk
+func TestReloadOnChange(t *testing.T) {
+	modules := writeModules(t, "modules.yaml", "modules:\n  - name: first\n    identifier: CHG1\n")
+	_, path := newServer(t, nil, ipcserver.WithModuleFile(modules, 10*time.Millisecond))
+
+	if _, err := dialModule(t, path, "CHG2"); err == nil {
+		t.Fatal("Expected the module to be unknown before the change")
+	}
+
+	content := "modules:\n  - name: first\n    identifier: CHG1\n  - name: second\n    identifier: CHG2\n"
+	if err := os.WriteFile(modules, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the added module to connect", func() bool {
+		_, err := dialModule(t, path, "CHG2")
+		return err == nil
+	})
+}
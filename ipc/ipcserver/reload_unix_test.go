@@ -0,0 +1,28 @@
+//go:build unix
+
+package ipcserver_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestReloadOnSIGHUP tests that the module definitions file is reloaded on SIGHUP
+func TestReloadOnSIGHUP(t *testing.T) {
+	modules := writeModules(t, "modules.txt", "first HUP1\n")
+	_, path := newServer(t, nil, ipcserver.WithModuleFile(modules, 0))
+
+	if err := os.WriteFile(modules, []byte("first HUP1\nsecond HUP2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the added module to connect", func() bool {
+		_, err := dialModule(t, path, "HUP2")
+		return err == nil
+	})
+}
@@ -0,0 +1,100 @@
+package ipcserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+type resourceKey struct {
+	method string
+	object string
+}
+
+type metadataKey struct{}
+
+// MetadataFromContext returns the decoded metadata of the request.
+// It is available in the context passed to the resource handlers, and to all handlers behind the Metadata middleware.
+func MetadataFromContext(ctx context.Context) (*ipc.GetJSON, bool) {
+	md, ok := ctx.Value(metadataKey{}).(*ipc.GetJSON)
+	return md, ok
+}
+
+// requestMetadata returns the metadata of the request, from the context if it was already decoded.
+// The context is returned with the metadata.
+func requestMetadata(ctx context.Context, req *ipc.IPCRequest) (*ipc.GetJSON, context.Context, error) {
+	if md, ok := MetadataFromContext(ctx); ok {
+		return md, ctx, nil
+	}
+	md, err := ipc.DecodeMetadata(&req.Message)
+	if err != nil {
+		return nil, ctx, err
+	}
+	return &md, context.WithValue(ctx, metadataKey{}, &md), nil
+}
+
+// HandleResource registers the handler for the requests with the given method (ipc.METHOD_GET, ...)
+// and destination object name in their metadata, REST-style.
+// Resource handlers take precedence over the handlers registered for the message type.
+//
+// Example:
+//
+//	mux.HandleResourceFunc(ipc.METHOD_GET, "threat_intel", func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+//		md, _ := ipcserver.MetadataFromContext(ctx)
+//		rows := db.RowsAfter(md.Metadata.Destination.Object.Database.RowID)
+//		...
+//	})
+func (m *ServeMux) HandleResource(method string, object string, h Handler) {
+	if h == nil {
+		panic("ipcserver: nil handler")
+	}
+	md := ipc.Metadata{Method: strings.ToUpper(method), Destination: ipc.Destination{Object: ipc.Object{Name: object}}}
+	if err := md.Validate(); err != nil {
+		panic("ipcserver: " + err.Error())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resources[resourceKey{md.Method, object}] = h
+}
+
+// HandleResourceFunc registers the handler function for the given method and destination object name.
+func (m *ServeMux) HandleResourceFunc(method string, object string, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	m.HandleResource(method, object, HandlerFunc(f))
+}
+
+// resource returns the resource handler for the request, and the context with the metadata of the request.
+// Requests with invalid metadata return an error wrapping ipc.ErrInvalidMetadata.
+func (m *ServeMux) resource(ctx context.Context, req *ipc.IPCRequest) (Handler, context.Context, bool, error) {
+	m.mu.RLock()
+	empty := len(m.resources) == 0
+	m.mu.RUnlock()
+	if empty {
+		return nil, ctx, false, nil
+	}
+
+	md, ctx, err := requestMetadata(ctx, req)
+	if errors.Is(err, ipc.ErrNoMetadata) {
+		return nil, ctx, false, nil
+	}
+	if err != nil {
+		return nil, ctx, false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok := m.resources[resourceKey{md.Metadata.Method, md.Metadata.Destination.Object.Name}]
+	return h, ctx, ok, nil
+}
+
+// HandleResource registers the handler for the given method and destination object name on the server's router
+func (s *IPCServer) HandleResource(method string, object string, h Handler) {
+	s.mux.HandleResource(method, object, h)
+}
+
+// HandleResourceFunc registers the handler function for the given method and destination object name on the server's router
+func (s *IPCServer) HandleResourceFunc(method string, object string, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	s.mux.HandleResourceFunc(method, object, f)
+}
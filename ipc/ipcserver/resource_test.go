@@ -0,0 +1,84 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// metadata returns a structured message for the method and destination object
+func metadata(method string, object string) ipc.GetJSON {
+	return ipc.GetJSON{
+		Metadata: ipc.Metadata{
+			Source:      "sigma",
+			Destination: ipc.Destination{Object: ipc.Object{Id: "1", Name: object}},
+			Method:      method,
+		},
+		Description: "test",
+	}
+}
+
+// TestResourceRouting tests that structured requests are routed on the method and destination object of their metadata
+func TestResourceRouting(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		for _, method := range []string{ipc.METHOD_GET, ipc.METHOD_POST} {
+			s.HandleResourceFunc(method, "threat_intel", func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+				md, ok := ipcserver.MetadataFromContext(ctx)
+				if !ok {
+					return nil, context.Canceled
+				}
+				return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte(md.Metadata.Method+" "+md.Metadata.Destination.Object.Name)), nil
+			})
+		}
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte("fallback")), nil
+		})
+	})
+	c := connect(t, path, "REST")
+
+	tests := []struct {
+		msg      interface{}
+		expected string
+	}{
+		{metadata(ipc.METHOD_GET, "threat_intel"), "GET threat_intel"},
+		{metadata("post", "threat_intel"), "POST threat_intel"},
+		{metadata(ipc.METHOD_DELETE, "threat_intel"), "fallback"},
+		{metadata(ipc.METHOD_GET, "logs"), "fallback"},
+		{map[string]string{"someKey": "someValue"}, "fallback"},
+	}
+
+	for _, test := range tests {
+		req, err := c.EncodeReq(test.msg, ipc.MSG_MSG, ipc.DATA_JSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.SendIPCMessage(req)
+		if err != nil || res.StringData != test.expected {
+			t.Errorf("Expected %q for %+v, but got %q, %v", test.expected, test.msg, res.StringData, err)
+		}
+	}
+
+	// The metadata is decoded with the codec of any structured datatype
+	for _, dataType := range []ipc.DataType{ipc.DATA_YAML, ipc.DATA_MSGPACK, ipc.DATA_CBOR} {
+		req, err := c.EncodeReq(metadata(ipc.METHOD_GET, "threat_intel"), ipc.MSG_MSG, dataType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res, err := c.SendIPCMessage(req); err != nil || res.StringData != "GET threat_intel" {
+			t.Errorf("Expected GET threat_intel for datatype 0x%02x, but got %q, %v", dataType, res.StringData, err)
+		}
+	}
+
+	req, err := c.EncodeReq(metadata(ipc.METHOD_GET, ""), ipc.MSG_MSG, ipc.DATA_JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.SendIPCMessage(req)
+	if !errors.Is(err, ipc.ErrDecode) || !strings.Contains(err.Error(), "invalid metadata") {
+		t.Errorf("Expected invalid metadata, but got %v", err)
+	}
+}
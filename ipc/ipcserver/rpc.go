@@ -0,0 +1,83 @@
+package ipcserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// HandleMethod registers the handler for the named method, called with MSG_RPC requests.
+// The handler receives the request with the payload of the call as its data, without the method name.
+// Register is the typed alternative.
+func (m *ServeMux) HandleMethod(method string, h Handler) {
+	if h == nil {
+		panic("ipcserver: nil handler")
+	}
+	if err := ipc.ValidateMethod(method); err != nil {
+		panic("ipcserver: " + err.Error())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.methods[method] = h
+}
+
+// HandleMethodFunc registers the handler function for the named method.
+func (m *ServeMux) HandleMethodFunc(method string, f func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error)) {
+	m.HandleMethod(method, HandlerFunc(f))
+}
+
+// serveMethod dispatches the MSG_RPC request to the handler of its method
+func (m *ServeMux) serveMethod(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+	method, payload, err := ipc.UnpackMethod(req.Message.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	h, ok := m.methods[method]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: method %s", ErrNoHandler.With("method", method), method)
+	}
+
+	call := *req
+	call.Message = ipc.IPCMessage{Datatype: req.Message.Datatype, Data: payload, StringData: string(payload)}
+	return h.ServeIPC(ctx, &call)
+}
+
+// MethodHandler returns a handler calling the function with the decoded payload of the call, and answering with
+// its encoded result, see ipc.DecodeData and ipc.EncodeData. An error of the function is sent back as MSG_ERROR.
+func MethodHandler[Req, Resp any](f func(ctx context.Context, req Req) (Resp, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+		var in Req
+		if len(req.Message.Data) > 0 { // The zero value without a payload
+			if err := ipc.DecodeData(req.Message.Datatype, req.Message.Data, &in); err != nil {
+				return nil, err
+			}
+		}
+
+		out, err := f(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		dataType, data, err := ipc.EncodeData(out)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ipc.ErrInternal, err)
+		}
+		return NewResponse(req, ipc.MSG_RPC, dataType, data), nil
+	})
+}
+
+// Register registers the function as the named method on the server's router.
+// Clients call it with ipcclient.Call, the request and the response are encoded for them.
+//
+// Example:
+//
+//	ipcserver.Register(server, "rules.count", func(ctx context.Context, table string) (int, error) {
+//		return db.Count(ctx, table)
+//	})
+func Register[Req, Resp any](s *IPCServer, method string, f func(ctx context.Context, req Req) (Resp, error)) {
+	s.mux.HandleMethod(method, MethodHandler(f))
+}
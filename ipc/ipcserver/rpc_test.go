@@ -0,0 +1,141 @@
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+type scanRequest struct {
+	Target string `json:"target"`
+	Ports  []int  `json:"ports"`
+}
+
+type scanResult struct {
+	Open []int `json:"open"`
+}
+
+// registerTestMethods registers the methods of the RPC tests
+func registerTestMethods(s *ipcserver.IPCServer) {
+	ipcserver.Register(s, "scan", func(ctx context.Context, req scanRequest) (scanResult, error) {
+		if req.Target == "" {
+			return scanResult{}, fmt.Errorf("%w: no target", ipc.ErrInvalidRequest)
+		}
+		return scanResult{Open: req.Ports[:1]}, nil
+	})
+	ipcserver.Register(s, "upper", func(ctx context.Context, s string) (string, error) {
+		return fmt.Sprintf("%s!", s), nil
+	})
+	ipcserver.Register(s, "count", func(ctx context.Context, _ struct{}) (int, error) {
+		return 3, nil
+	})
+}
+
+func rpcServer(t *testing.T) string {
+	t.Helper()
+
+	return startServer(t, registerTestMethods)
+}
+
+// TestRPC tests that registered methods are called with the decoded request, and answer with typed results
+func TestRPC(t *testing.T) {
+	c := connect(t, rpcServer(t), "RPCS")
+	ctx := context.Background()
+
+	res, err := ipcclient.Call[scanRequest, scanResult](ctx, c, "scan", scanRequest{Target: "10.0.0.1", Ports: []int{22, 80}})
+	if err != nil || len(res.Open) != 1 || res.Open[0] != 22 {
+		t.Errorf("Expected port 22 open, but got %+v, %v", res, err)
+	}
+
+	s, err := ipcclient.Call[string, string](ctx, c, "upper", "hello")
+	if err != nil || s != "hello!" {
+		t.Errorf("Expected hello!, but got %q, %v", s, err)
+	}
+
+	n, err := ipcclient.Call[struct{}, int](ctx, c, "count", struct{}{})
+	if err != nil || n != 3 {
+		t.Errorf("Expected 3, but got %d, %v", n, err)
+	}
+}
+
+// TestRPCErrors tests that the errors of methods, unknown methods and undecodable requests reach the client
+func TestRPCErrors(t *testing.T) {
+	c := connect(t, rpcServer(t), "RPCE")
+	ctx := context.Background()
+
+	var ipcErr *ipc.Error
+	_, err := ipcclient.Call[scanRequest, scanResult](ctx, c, "scan", scanRequest{})
+	if !errors.Is(err, ipc.ErrInvalidRequest) || !errors.As(err, &ipcErr) {
+		t.Errorf("Expected ErrInvalidRequest from the method, but got %v", err)
+	}
+
+	_, err = ipcclient.Call[string, string](ctx, c, "missing", "hello")
+	if !errors.Is(err, ipc.ErrNoHandler) || !errors.As(err, &ipcErr) || ipcErr.Details["method"] != "missing" {
+		t.Errorf("Expected ErrNoHandler for the method, but got %v", err)
+	}
+
+	_, err = ipcclient.Call[string, scanResult](ctx, c, "scan", "10.0.0.1")
+	if !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for a text request, but got %v", err)
+	}
+
+	// The response of upper can't be decoded as an int on the client
+	_, err = ipcclient.Call[string, int](ctx, c, "upper", "hello")
+	if !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for a text response, but got %v", err)
+	}
+
+	// The connection is still usable
+	if s, err := ipcclient.Call[string, string](ctx, c, "upper", "ok"); err != nil || s != "ok!" {
+		t.Errorf("Expected ok!, but got %q, %v", s, err)
+	}
+}
+
+// TestEncodeReqType tests that a message of the wrong type for the datatype is an error, and doesn't panic
+func TestEncodeReqType(t *testing.T) {
+	c := &ipcclient.IPCClient{Identifier: [4]byte{'T', 'Y', 'P', 'E'}}
+	for _, dataType := range []ipc.DataType{ipc.DATA_TEXT, ipc.DATA_INT, ipc.DATA_BIN} {
+		if req, err := c.EncodeReq(struct{}{}, ipc.MSG_MSG, dataType); err == nil {
+			t.Errorf("Expected an error for datatype 0x%02x, but got %+v", dataType, req)
+		}
+		if req := c.CreateGenericReq(struct{}{}, ipc.MSG_MSG, dataType); req != nil {
+			t.Errorf("Expected no request for datatype 0x%02x, but got %+v", dataType, req)
+		}
+	}
+}
+
+// TestDataCodecs tests that a request encoded with a registered codec is decoded by the server, and the response by the client
+func TestDataCodecs(t *testing.T) {
+	path := startServer(t, func(s *ipcserver.IPCServer) {
+		s.HandleDataFunc(ipc.MSG_MSG, ipc.DATA_CBOR, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			var scan scanRequest
+			if err := req.Message.Decode(&scan); err != nil {
+				return nil, err
+			}
+			data, err := ipc.MarshalData(ipc.DATA_MSGPACK, scanResult{Open: scan.Ports})
+			if err != nil {
+				return nil, err
+			}
+			return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_MSGPACK, data), nil
+		})
+	})
+	c := connect(t, path, "CODC")
+
+	req, err := c.EncodeReq(scanRequest{Target: "10.0.0.1", Ports: []int{22, 443}}, ipc.MSG_MSG, ipc.DATA_CBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Call(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed to call: %v", err)
+	}
+	var result scanResult
+	if err := res.Decode(&result); err != nil || res.Datatype != ipc.DATA_MSGPACK || len(result.Open) != 2 || result.Open[1] != 443 {
+		t.Errorf("Expected ports 22 and 443 as MessagePack, but got %+v from 0x%02x, %v", result, res.Datatype, err)
+	}
+}
@@ -0,0 +1,117 @@
+package ipcserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// DefaultSocketMode is the mode of the socket file, unless set with WithSocketMode
+const DefaultSocketMode os.FileMode = 0600
+
+// ErrInsecureSocketDir is returned by Serve when the directory of the socket is owned or can be written by other users
+var ErrInsecureSocketDir = errors.New("ipcserver: insecure socket directory")
+
+// listen creates the directory of the socket, and listens on the socket with the configured mode and group.
+// Abstract sockets have no file, they are listened on as is. TCP servers listen on their address instead.
+func (s *IPCServer) listen() (net.Listener, error) {
+	if s.tcp() {
+		return s.listenTCP()
+	}
+	if ipc.IsAbstractSock(s.path) {
+		return net.Listen(AF_UNIX, s.path)
+	}
+
+	gid, err := s.socketGid()
+	if err != nil {
+		return nil, err
+	}
+	if err := socketDir(filepath.Dir(s.path), gid); err != nil {
+		return nil, err
+	}
+
+	if err := s.InitServerSocket(); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(AF_UNIX, s.path)
+	if err != nil {
+		s.unlock()
+		return nil, err
+	}
+
+	if err := s.setSocketMode(s.path, gid); err != nil {
+		ln.Close()
+		s.unlock()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// socketGid returns the id of the socket group, -1 if there is none
+func (s *IPCServer) socketGid() (int, error) {
+	if s.socketGroup == "" {
+		return -1, nil
+	}
+	id, err := lookupId(s.socketGroup, user.LookupGroup, func(g *user.Group) string { return g.Gid })
+	if err != nil {
+		return -1, fmt.Errorf("socket group %q: %w", s.socketGroup, err)
+	}
+	return int(id), nil
+}
+
+// setSocketMode sets the configured mode of the socket file, and its group if the gid isn't -1.
+// Until then, the directory keeps the other users away from the socket.
+func (s *IPCServer) setSocketMode(path string, gid int) error {
+	mode := s.socketMode
+	if mode == 0 {
+		mode = DefaultSocketMode
+		if gid >= 0 {
+			mode = 0660
+		}
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, mode)
+}
+
+// socketDir creates the directory of the socket with mode 0700, or 0710 for the group if it isn't -1.
+// An existing directory is used as is, unless it is owned by another user than root, or other users can write to it
+// without the sticky bit, as they could replace the socket.
+func socketDir(dir string, gid int) error {
+	info, err := os.Lstat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		if gid < 0 {
+			return nil
+		}
+		if err := os.Chown(dir, -1, gid); err != nil {
+			return err
+		}
+		return os.Chmod(dir, 0710)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInsecureSocketDir, dir)
+	}
+	if uid, ok := fileOwner(info); ok && uid != 0 && uid != os.Geteuid() {
+		return fmt.Errorf("%w: %s is owned by uid %d", ErrInsecureSocketDir, dir, uid)
+	}
+	if info.Mode().Perm()&0002 != 0 && info.Mode()&os.ModeSticky == 0 {
+		return fmt.Errorf("%w: %s is writable by everyone", ErrInsecureSocketDir, dir)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package ipcserver_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestAbstractSocket tests a server listening in the abstract namespace, without a socket file
+func TestAbstractSocket(t *testing.T) {
+	path := fmt.Sprintf("@pynezzentials-test-%d", os.Getpid())
+	serveAt(t, path, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+
+	c := connect(t, path, "ABST")
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo, but got %q, %v", res.StringData, err)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build unix
+
+package ipcserver_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// TestSocketPermissions tests that the server creates the socket directory, and sets the mode and group of the socket
+func TestSocketPermissions(t *testing.T) {
+	gid := strconv.Itoa(os.Getgid())
+
+	tests := []struct {
+		name    string
+		opts    []ipcserver.Option
+		dirMode os.FileMode
+		mode    os.FileMode
+	}{
+		{"default", nil, 0700, ipcserver.DefaultSocketMode},
+		{"mode", []ipcserver.Option{ipcserver.WithSocketMode(0660)}, 0700, 0660},
+		{"group", []ipcserver.Option{ipcserver.WithSocketGroup(gid)}, 0710, 0660},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "run", "test")
+			_, path := serveAt(t, filepath.Join(dir, "test.sock"), nil, tt.opts...)
+
+			info, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.dirMode {
+				t.Errorf("Expected the directory mode %v, but got %v", tt.dirMode, info.Mode().Perm())
+			}
+
+			info, err = os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.mode {
+				t.Errorf("Expected the socket mode %v, but got %v", tt.mode, info.Mode().Perm())
+			}
+			if st, ok := info.Sys().(*syscall.Stat_t); ok && strconv.Itoa(int(st.Gid)) != gid {
+				t.Errorf("Expected the socket group %s, but got %d", gid, st.Gid)
+			}
+		})
+	}
+}
+
+// TestInsecureSocketDir tests that the server refuses a socket directory that everyone can write to
+func TestInsecureSocketDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "open")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	server := ipcserver.NewIPCServer("test", "TEST", ipcserver.WithSocketPath(filepath.Join(dir, "test.sock")))
+	if err := server.Serve(context.Background()); !errors.Is(err, ipcserver.ErrInsecureSocketDir) {
+		t.Errorf("Expected ErrInsecureSocketDir, but got %v", err)
+	}
+}
+
+// TestSocketDirOwner tests that the server refuses a socket directory owned by another user
+func TestSocketDirOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner of the directory requires root")
+	}
+	dir := filepath.Join(t.TempDir(), "other")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(dir, 65534, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	server := ipcserver.NewIPCServer("test", "TEST", ipcserver.WithSocketPath(filepath.Join(dir, "test.sock")))
+	if err := server.Serve(context.Background()); !errors.Is(err, ipcserver.ErrInsecureSocketDir) {
+		t.Errorf("Expected ErrInsecureSocketDir, but got %v", err)
+	}
+}
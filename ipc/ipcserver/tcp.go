@@ -0,0 +1,65 @@
+package ipcserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// tcp returns true if the server listens on TCP instead of a UNIX domain socket, see WithTCP
+func (s *IPCServer) tcp() bool {
+	return s.addr != ""
+}
+
+// address returns the TCP address or the socket path the server listens on, as configured
+func (s *IPCServer) address() string {
+	if s.tcp() {
+		return s.addr
+	}
+	return s.path
+}
+
+// Addr returns the address the server listens on, nil until it is listening.
+// With a TCP address ending in :0, it has the port picked by the system.
+func (s *IPCServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Addr()
+}
+
+// listenTCP listens on the TCP address, with TLS if it is configured
+func (s *IPCServer) listenTCP() (net.Listener, error) {
+	ln, err := net.Listen(ipc.Network, s.addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	return ln, nil
+}
+
+// tlsHandshake completes the TLS handshake of the connection within ipc.Timeout, before the first request is read, and keeps
+// the certificate of the client if it presented one. Connections without TLS are left as they are.
+func (cn *connection) tlsHandshake() error {
+	tc, ok := cn.c.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	// Bounded like the MSG_CONN handshake, so a client that never speaks TLS doesn't hold a slot forever
+	ctx, cancel := context.WithTimeout(cn.ctx, ipc.Timeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+		cn.cert = certs[0]
+		cn.log = cn.log.With("subject", cn.cert.Subject.String())
+	}
+	return nil
+}
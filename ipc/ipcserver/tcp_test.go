@@ -0,0 +1,288 @@
+package ipcserver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+	"github.com/pynezz/pynezzentials/ipc/ipcclient"
+	"github.com/pynezz/pynezzentials/ipc/ipcserver"
+)
+
+// certAuthority issues the certificates of a test, written to PEM files in a temporary directory
+type certAuthority struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of the CA certificate
+}
+
+func newCertAuthority(t *testing.T, name string) *certAuthority {
+	t.Helper()
+
+	ca := &certAuthority{t: t, dir: t.TempDir()}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	ca.cert, ca.key, ca.file, _ = ca.issue(template, name)
+	return ca
+}
+
+// issue signs the certificate with the CA, or self-signs it if the CA has none yet, and returns the paths of the PEM files
+func (ca *certAuthority) issue(template *x509.Certificate, name string) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	ca.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("Failed to generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parent, parentKey := ca.cert, ca.key
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		ca.t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		ca.t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		ca.t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(ca.dir, name+".crt")
+	keyFile := filepath.Join(ca.dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		ca.t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		ca.t.Fatal(err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+// server issues a certificate for localhost, and returns the paths of the certificate and key files
+func (ca *certAuthority) server() (string, string) {
+	_, _, certFile, keyFile := ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, "server")
+	return certFile, keyFile
+}
+
+// client issues a client certificate for the module, and returns the paths of the certificate and key files
+func (ca *certAuthority) client(name string) (string, string) {
+	_, _, certFile, keyFile := ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, name)
+	return certFile, keyFile
+}
+
+// serveTCP starts a server on a free port of localhost, and returns its address
+func serveTCP(t *testing.T, register func(s *ipcserver.IPCServer), opts ...ipcserver.Option) string {
+	t.Helper()
+
+	opts = append([]ipcserver.Option{ipcserver.WithTCP("127.0.0.1:0"), ipcserver.WithSocketPath(filepath.Join(t.TempDir(), "unused.sock"))}, opts...)
+	server := ipcserver.NewIPCServer("test", "TEST", opts...)
+	if register != nil {
+		register(server)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; !errors.Is(err, ipcserver.ErrServerClosed) {
+			t.Errorf("Expected ErrServerClosed from Serve, but got %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if addr := server.Addr(); addr != nil {
+			return addr.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Server did not start listening on TCP")
+	return ""
+}
+
+// dialTCP registers the module and returns a client for it, connecting to the TCP address
+func dialTCP(addr string, identifier string, opts ...ipcclient.Option) *ipcclient.IPCClient {
+	ipcserver.AddModule("module "+identifier, []byte(identifier))
+	opts = append([]ipcclient.Option{ipcclient.WithTCP(addr)}, opts...)
+	return ipcclient.NewIPCClient("client "+identifier, identifier, "test", opts...)
+}
+
+// TestTCP tests the protocol over plain TCP
+func TestTCP(t *testing.T) {
+	addr := serveTCP(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	})
+
+	c := dialTCP(addr, "TCP1")
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo, but got %q, %v", res.StringData, err)
+	}
+}
+
+// TestMutualTLS tests that clients connect over TLS with a certificate of the CA, and that the others are refused
+func TestMutualTLS(t *testing.T) {
+	ca := newCertAuthority(t, "ca")
+	certFile, keyFile := ca.server()
+	serverConfig, err := ipc.ServerTLSConfig(certFile, keyFile, ca.file)
+	if err != nil {
+		t.Fatalf("Failed to load the server configuration: %v", err)
+	}
+
+	addr := serveTCP(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, func(ctx context.Context, req *ipc.IPCRequest) (*ipc.IPCRequest, error) {
+			info, ok := ipcserver.ConnInfoFromContext(ctx)
+			if !ok || info.Cert == nil {
+				return nil, errors.New("no client certificate")
+			}
+			return ipcserver.NewResponse(req, ipc.MSG_MSG, ipc.DATA_TEXT, []byte(info.Cert.Subject.CommonName)), nil
+		})
+	}, ipcserver.WithTLS(serverConfig))
+
+	clientCert, clientKey := ca.client("module MTLS")
+	config, err := ipc.ClientTLSConfig(ca.file, clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("Failed to load the client configuration: %v", err)
+	}
+	c := dialTCP(addr, "MTLS", ipcclient.WithTLS(config))
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "module MTLS" {
+		t.Errorf("Expected the common name of the client certificate, but got %q, %v", res.StringData, err)
+	}
+
+	other := newCertAuthority(t, "other")
+	otherCert, otherKey := other.client("module MTLS")
+	refused := map[string][3]string{
+		"without a certificate":            {ca.file, "", ""},
+		"with a certificate of another CA": {ca.file, otherCert, otherKey},
+		"trusting another CA":              {other.file, clientCert, clientKey},
+	}
+	for name, files := range refused {
+		config, err := ipc.ClientTLSConfig(files[0], files[1], files[2])
+		if err != nil {
+			t.Fatalf("Failed to load the client configuration: %v", err)
+		}
+		c := dialTCP(addr, "MTLS", ipcclient.WithTLS(config))
+		if err := c.Connect(); err == nil {
+			c.Close()
+			t.Errorf("Expected the connection %s to be refused", name)
+		}
+	}
+}
+
+// TestMutualTLSModule tests that a client certificate of the CA only connects as the module it names
+func TestMutualTLSModule(t *testing.T) {
+	ca := newCertAuthority(t, "ca")
+	certFile, keyFile := ca.server()
+	serverConfig, err := ipc.ServerTLSConfig(certFile, keyFile, ca.file)
+	if err != nil {
+		t.Fatalf("Failed to load the server configuration: %v", err)
+	}
+	addr := serveTCP(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	}, ipcserver.WithTLS(serverConfig))
+	if err := ipcserver.LoadModules(writeModules(t, "modules.txt", "certbound CRTB cert=sigma.modules.test\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	clientCert, clientKey := ca.client("sigma.modules.test")
+	config, err := ipc.ClientTLSConfig(ca.file, clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("Failed to load the client configuration: %v", err)
+	}
+
+	// The certificate of the definition, in place of the module name
+	c := ipcclient.NewIPCClient("certbound", "CRTB", "test", ipcclient.WithTCP(addr), ipcclient.WithTLS(config))
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if res, err := c.SendIPCMessage(c.CreateReq("hello", ipc.MSG_MSG, ipc.DATA_TEXT)); err != nil || res.StringData != "hello" {
+		t.Errorf("Expected the echo, but got %q, %v", res.StringData, err)
+	}
+
+	// The same certificate can't claim another module
+	other := dialTCP(addr, "CRTO", ipcclient.WithTLS(config))
+	if err := other.Connect(); !errors.Is(err, ipc.ErrUnauthorized) {
+		other.Close()
+		t.Errorf("Expected ErrUnauthorized for the certificate of another module, but got %v", err)
+	}
+}
+
+// TestTLSHandshakeTimeout tests that a client that never speaks TLS is disconnected, and gives its slot back
+func TestTLSHandshakeTimeout(t *testing.T) {
+	ca := newCertAuthority(t, "ca")
+	certFile, keyFile := ca.server()
+	serverConfig, err := ipc.ServerTLSConfig(certFile, keyFile, ca.file)
+	if err != nil {
+		t.Fatalf("Failed to load the server configuration: %v", err)
+	}
+	addr := serveTCP(t, func(s *ipcserver.IPCServer) {
+		s.HandleFunc(ipc.MSG_MSG, echo)
+	}, ipcserver.WithTLS(serverConfig), ipcserver.WithMaxConnections(1))
+
+	conn, err := net.Dial(ipc.Network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(ipc.Timeout + 5*time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the silent connection to be closed, but got %v", err)
+	}
+
+	clientCert, clientKey := ca.client("module TLST")
+	config, err := ipc.ClientTLSConfig(ca.file, clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("Failed to load the client configuration: %v", err)
+	}
+	c := dialTCP(addr, "TLST", ipcclient.WithTLS(config))
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Expected the slot to be free, but failed to connect: %v", err)
+	}
+	c.Close()
+}
@@ -0,0 +1,74 @@
+package ipc
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+// MAX_LOGGED_PAYLOAD is how many bytes of a payload are logged, when payload logging is enabled
+const MAX_LOGGED_PAYLOAD = 256
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(slog.New(slog.DiscardHandler))
+}
+
+// SetLogger sets the default logger of the ipc, ipcserver and ipcclient packages.
+// Servers and clients without a logger of their own (see their WithLogger options) use it.
+// The default logger discards everything.
+//
+// Example:
+//
+//	ipc.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+	logger.Store(l)
+}
+
+// Logger returns the default logger of the ipc packages
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// Redactor returns what may be logged of the payload of a message.
+// It is called only when payload logging is enabled.
+//
+// Example, hiding the payloads of a module that sends credentials:
+//
+//	func(msg *ipc.IPCRequest) string {
+//		if msg.Header.Identifier == [4]byte{'V', 'A', 'L', 'T'} {
+//			return "[REDACTED]"
+//		}
+//		return msg.Message.StringData
+//	}
+type Redactor func(msg *IPCRequest) string
+
+// PayloadAttr returns the payload of the message as a log attribute, as returned by the redactor.
+// A nil redactor logs the payload as is. Payloads longer than MAX_LOGGED_PAYLOAD bytes are truncated.
+func PayloadAttr(msg *IPCRequest, redact Redactor) slog.Attr {
+	var payload string
+	if redact != nil {
+		payload = redact(msg)
+	} else {
+		payload = string(msg.Message.Data)
+	}
+	if len(payload) > MAX_LOGGED_PAYLOAD {
+		payload = payload[:MAX_LOGGED_PAYLOAD] + "..."
+	}
+	return slog.String("payload", payload)
+}
+
+// MessageAttr returns the header of the message as a log attribute group
+func MessageAttr(msg *IPCRequest) slog.Attr {
+	return slog.Group("msg",
+		slog.String("identifier", string(msg.Header.Identifier[:])),
+		slog.String("type", fmt.Sprintf("0x%02x", msg.Header.MessageType)),
+		slog.Uint64("id", uint64(msg.Header.MessageId)),
+		slog.Int("datatype", int(msg.Message.Datatype)),
+		slog.Int("size", len(msg.Message.Data)),
+	)
+}
@@ -0,0 +1,39 @@
+package ipc_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestPayloadAttr(t *testing.T) {
+	long := strings.Repeat("a", ipc.MAX_LOGGED_PAYLOAD+10)
+	msg := &ipc.IPCRequest{Message: ipc.IPCMessage{Data: []byte(long), StringData: long}}
+
+	if got := ipc.PayloadAttr(msg, nil).Value.String(); got != long[:ipc.MAX_LOGGED_PAYLOAD]+"..." {
+		t.Errorf("Expected the payload to be truncated, but got %d bytes", len(got))
+	}
+
+	redact := func(*ipc.IPCRequest) string { return "[REDACTED]" }
+	if got := ipc.PayloadAttr(msg, redact).Value.String(); got != "[REDACTED]" {
+		t.Errorf("Expected the redacted payload, but got %q", got)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	defer ipc.SetLogger(nil)
+
+	l := slog.New(slog.NewTextHandler(&strings.Builder{}, nil))
+	ipc.SetLogger(l)
+	if ipc.Logger() != l {
+		t.Error("Expected the logger to be set")
+	}
+
+	ipc.SetLogger(nil)
+	if ipc.Logger().Enabled(context.Background(), slog.LevelError) {
+		t.Error("Expected a nil logger to discard everything")
+	}
+}
@@ -0,0 +1,110 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Methods of the metadata, to differentiate between requests on the same object
+const (
+	METHOD_GET    = "GET"    // Get data from the object
+	METHOD_POST   = "POST"   // Send data to the object
+	METHOD_PUT    = "PUT"    // Update data in the object
+	METHOD_DELETE = "DELETE" // Delete data from the object
+)
+
+var (
+	ErrNoMetadata      = errors.New("ipc: message has no metadata")
+	ErrInvalidMetadata = fmt.Errorf("%w: invalid metadata", ErrDecode)
+)
+
+// envelope is the shape of a structured message that may carry metadata
+type envelope struct {
+	Metadata    *Metadata `json:"metadata" yaml:"metadata"`
+	Description string    `json:"description" yaml:"description"`
+}
+
+// DecodeMetadata decodes the metadata of a structured message with the registered codec of its datatype
+// (see RegisterDataCodec), and validates it.
+// It returns ErrNoMetadata if the datatype can't carry metadata, or the message has none,
+// and an error wrapping ErrInvalidMetadata if the metadata can't be decoded or is incomplete.
+// The method is normalized to upper case.
+//
+// Example message:
+//
+//	{
+//		"metadata": {
+//			"source": "sigma",
+//			"destination": {"destination": {"id": "1", "name": "database", "info": "table=threat_intel"}},
+//			"method": "GET"
+//		},
+//		"description": "Fetch the latest threat intel"
+//	}
+func DecodeMetadata(msg *IPCMessage) (GetJSON, error) {
+	c, ok := DataCodecFor(msg.Datatype)
+	if !ok {
+		return GetJSON{}, ErrNoMetadata
+	}
+
+	var fields map[string]any
+	if err := c.Unmarshal(msg.Data, &fields); err != nil {
+		return GetJSON{}, ErrNoMetadata // Only maps carry metadata, which text, integers and binary data can't be decoded into
+	}
+	if _, ok := fields["metadata"]; !ok {
+		return GetJSON{}, ErrNoMetadata
+	}
+
+	var env envelope
+	if err := c.Unmarshal(msg.Data, &env); err != nil {
+		return GetJSON{}, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if env.Metadata == nil {
+		return GetJSON{}, fmt.Errorf("%w: empty metadata", ErrInvalidMetadata)
+	}
+
+	env.Metadata.Method = strings.ToUpper(env.Metadata.Method)
+	if err := env.Metadata.Validate(); err != nil {
+		return GetJSON{}, err
+	}
+	return GetJSON{Metadata: *env.Metadata, Description: env.Description}, nil
+}
+
+// Validate checks that the metadata has a known method and a destination object
+func (m Metadata) Validate() error {
+	switch m.Method {
+	case METHOD_GET, METHOD_POST, METHOD_PUT, METHOD_DELETE:
+	case "":
+		return fmt.Errorf("%w: missing method", ErrInvalidMetadata)
+	default:
+		return fmt.Errorf("%w: unknown method %q", ErrInvalidMetadata, m.Method)
+	}
+	if m.Destination.Object.Name == "" {
+		return fmt.Errorf("%w: missing destination name", ErrInvalidMetadata)
+	}
+	return nil
+}
+
+// String describes the request of the metadata in a sentence
+func (m Metadata) String() string {
+	verbs := map[string]string{
+		METHOD_GET:    "get data from",
+		METHOD_POST:   "send data to",
+		METHOD_PUT:    "update data in",
+		METHOD_DELETE: "delete data from",
+	}
+	verb, ok := verbs[m.Method]
+	if !ok {
+		verb = "???"
+	}
+
+	object := m.Destination.Object
+	s := fmt.Sprintf("%s wants to %s %s", m.Source, verb, object.Name)
+	if object.Id != "" {
+		s += " with id " + object.Id
+	}
+	if object.Info != "" {
+		s += " (" + object.Info + ")"
+	}
+	return s
+}
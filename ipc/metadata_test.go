@@ -0,0 +1,67 @@
+package ipc_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestDecodeMetadata(t *testing.T) {
+	metadata := map[string]any{
+		"metadata": map[string]any{
+			"source":      "sigma",
+			"destination": map[string]any{"destination": map[string]any{"id": "1", "name": "database"}},
+			"method":      "GET",
+		},
+		"description": "latest",
+	}
+	encode := func(dataType ipc.DataType, v any) string {
+		data, err := ipc.MarshalData(dataType, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		name     string
+		datatype ipc.DataType
+		data     string
+		err      error
+	}{
+		{"json", ipc.DATA_JSON, `{"metadata": {"source": "sigma", "destination": {"destination": {"id": "1", "name": "database", "info": "table=threat_intel"}}, "method": "get"}, "description": "latest"}`, nil},
+		{"yaml", ipc.DATA_YAML, "metadata:\n  source: sigma\n  destination:\n    destination:\n      id: \"1\"\n      name: database\n      info: table=threat_intel\n  method: GET\ndescription: latest\n", nil},
+		{"without info", ipc.DATA_JSON, `{"metadata": {"source": "sigma", "destination": {"destination": {"id": "1", "name": "database"}}, "method": "GET"}, "description": "latest"}`, nil},
+		{"without destination", ipc.DATA_JSON, `{"metadata": {"source": "sigma", "method": "GET"}}`, ipc.ErrInvalidMetadata},
+		{"unknown method", ipc.DATA_JSON, `{"metadata": {"destination": {"destination": {"name": "database"}}, "method": "PATCH"}}`, ipc.ErrInvalidMetadata},
+		{"wrong type", ipc.DATA_JSON, `{"metadata": {"destination": "database", "method": "GET"}}`, ipc.ErrInvalidMetadata},
+		{"null", ipc.DATA_JSON, `{"metadata": null}`, ipc.ErrInvalidMetadata},
+		{"no metadata", ipc.DATA_JSON, `{"someKey": "someValue"}`, ipc.ErrNoMetadata},
+		{"array", ipc.DATA_JSON, `[1, 2, 3]`, ipc.ErrNoMetadata},
+		{"yaml scalar", ipc.DATA_YAML, `hello`, ipc.ErrNoMetadata},
+		{"text", ipc.DATA_TEXT, `{"metadata": {}}`, ipc.ErrNoMetadata},
+		{"msgpack", ipc.DATA_MSGPACK, encode(ipc.DATA_MSGPACK, metadata), nil},
+		{"cbor", ipc.DATA_CBOR, encode(ipc.DATA_CBOR, metadata), nil},
+		{"cbor without metadata", ipc.DATA_CBOR, encode(ipc.DATA_CBOR, map[string]any{"someKey": "someValue"}), ipc.ErrNoMetadata},
+		{"unregistered datatype", ipc.DATA_CUSTOM + 0x7F, `{"metadata": {}}`, ipc.ErrNoMetadata},
+	}
+
+	for _, test := range tests {
+		md, err := ipc.DecodeMetadata(&ipc.IPCMessage{Datatype: test.datatype, Data: []byte(test.data)})
+		if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if md.Metadata.Method != ipc.METHOD_GET || md.Metadata.Source != "sigma" || md.Description != "latest" {
+			t.Errorf("%s: unexpected metadata %+v", test.name, md)
+		}
+		if object := md.Metadata.Destination.Object; object.Name != "database" || object.Id != "1" {
+			t.Errorf("%s: unexpected destination %+v", test.name, object)
+		}
+	}
+}
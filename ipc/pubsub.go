@@ -0,0 +1,82 @@
+package ipc
+
+import (
+	"fmt"
+)
+
+// MAX_TOPIC_LENGTH is the maximum length of a topic name in bytes
+const MAX_TOPIC_LENGTH = 255
+
+// ErrInvalidTopic is returned for empty topics, and topics longer than MAX_TOPIC_LENGTH
+var ErrInvalidTopic = fmt.Errorf("%w: invalid topic", ErrInvalidRequest)
+
+// Event is a message published to a topic
+type Event struct {
+	Topic   string     // Topic the event was published to
+	Source  [4]byte    // Identifier of the module that published the event
+	Message IPCMessage // The payload of the event
+}
+
+// ValidateTopic checks that the topic can be subscribed and published to
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("%w: empty topic", ErrInvalidTopic)
+	}
+	if len(topic) > MAX_TOPIC_LENGTH {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrInvalidTopic, len(topic), MAX_TOPIC_LENGTH)
+	}
+	return nil
+}
+
+// PackTopic packs the topic and the payload into the data of a MSG_PUBLISH message.
+//
+// Layout:
+//
+//	length  1 byte    length of the topic
+//	topic   length    the topic
+//	payload the rest  the published data
+func PackTopic(topic string, payload []byte) ([]byte, error) {
+	if err := ValidateTopic(topic); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 1+len(topic)+len(payload))
+	data = append(data, byte(len(topic)))
+	data = append(data, topic...)
+	return append(data, payload...), nil
+}
+
+// UnpackTopic returns the topic and the payload of the data of a MSG_PUBLISH message
+func UnpackTopic(data []byte) (string, []byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return "", nil, fmt.Errorf("%w: truncated publish message", ErrInvalidTopic)
+	}
+
+	n := int(data[0])
+	topic := string(data[1 : 1+n])
+	if err := ValidateTopic(topic); err != nil {
+		return "", nil, err
+	}
+	return topic, data[1+n:], nil
+}
+
+// EventFromRequest returns the event carried by a MSG_PUBLISH message
+func EventFromRequest(req *IPCRequest) (Event, error) {
+	if req.Header.MessageType != MSG_PUBLISH {
+		return Event{}, fmt.Errorf("ipc: message type 0x%02x is not a publish message", req.Header.MessageType)
+	}
+
+	topic, payload, err := UnpackTopic(req.Message.Data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Topic:  topic,
+		Source: req.Header.Identifier,
+		Message: IPCMessage{
+			Datatype:   req.Message.Datatype,
+			Data:       payload,
+			StringData: string(payload),
+		},
+	}, nil
+}
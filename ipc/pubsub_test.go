@@ -0,0 +1,38 @@
+package ipc_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestPackTopic(t *testing.T) {
+	data, err := ipc.PackTopic("threat_intel.inserted", []byte(`{"row_id": 42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, payload, err := ipc.UnpackTopic(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic != "threat_intel.inserted" || string(payload) != `{"row_id": 42}` {
+		t.Errorf("Expected the topic and payload back, but got %q, %q", topic, payload)
+	}
+}
+
+func TestPackTopicErrors(t *testing.T) {
+	for _, topic := range []string{"", strings.Repeat("t", ipc.MAX_TOPIC_LENGTH+1)} {
+		if _, err := ipc.PackTopic(topic, nil); !errors.Is(err, ipc.ErrInvalidTopic) {
+			t.Errorf("Expected ErrInvalidTopic for a topic of %d bytes, but got %v", len(topic), err)
+		}
+	}
+
+	for _, data := range [][]byte{nil, {0}, {5, 't', 'o'}} {
+		if _, _, err := ipc.UnpackTopic(data); !errors.Is(err, ipc.ErrInvalidTopic) {
+			t.Errorf("Expected ErrInvalidTopic for %v, but got %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package ipc
+
+import "fmt"
+
+// MAX_METHOD_LENGTH is the maximum length of a method name in bytes
+const MAX_METHOD_LENGTH = 255
+
+// ErrInvalidMethod is returned for empty method names, and method names longer than MAX_METHOD_LENGTH
+var ErrInvalidMethod = fmt.Errorf("%w: invalid method", ErrInvalidRequest)
+
+// ValidateMethod checks that the method name can be called
+func ValidateMethod(method string) error {
+	if method == "" {
+		return fmt.Errorf("%w: empty method", ErrInvalidMethod)
+	}
+	if len(method) > MAX_METHOD_LENGTH {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrInvalidMethod, len(method), MAX_METHOD_LENGTH)
+	}
+	return nil
+}
+
+// PackMethod packs the method name and the payload into the data of a MSG_RPC message, with the layout of PackTopic
+func PackMethod(method string, payload []byte) ([]byte, error) {
+	if err := ValidateMethod(method); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 1+len(method)+len(payload))
+	data = append(data, byte(len(method)))
+	data = append(data, method...)
+	return append(data, payload...), nil
+}
+
+// UnpackMethod returns the method name and the payload of the data of a MSG_RPC message
+func UnpackMethod(data []byte) (string, []byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return "", nil, fmt.Errorf("%w: truncated rpc message", ErrInvalidMethod)
+	}
+
+	n := int(data[0])
+	method := string(data[1 : 1+n])
+	if err := ValidateMethod(method); err != nil {
+		return "", nil, err
+	}
+	return method, data[1+n:], nil
+}
@@ -0,0 +1,95 @@
+package ipc_test
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestPackMethod(t *testing.T) {
+	data, err := ipc.PackMethod("rules.count", []byte("sigma"))
+	if err != nil {
+		t.Fatalf("Failed to pack method: %v", err)
+	}
+	method, payload, err := ipc.UnpackMethod(data)
+	if err != nil || method != "rules.count" || string(payload) != "sigma" {
+		t.Errorf("Expected rules.count with sigma, but got %q with %q, %v", method, payload, err)
+	}
+
+	if _, err := ipc.PackMethod("", nil); !errors.Is(err, ipc.ErrInvalidMethod) {
+		t.Errorf("Expected ErrInvalidMethod for an empty method, but got %v", err)
+	}
+	if _, err := ipc.PackMethod(strings.Repeat("m", ipc.MAX_METHOD_LENGTH+1), nil); !errors.Is(err, ipc.ErrInvalidMethod) {
+		t.Errorf("Expected ErrInvalidMethod for a long method, but got %v", err)
+	}
+	if _, _, err := ipc.UnpackMethod(data[:4]); !errors.Is(err, ipc.ErrInvalidMethod) || !errors.Is(err, ipc.ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidMethod for a truncated message, but got %v", err)
+	}
+}
+
+func TestEncodeData(t *testing.T) {
+	type rule struct {
+		Name  string `json:"name"`
+		Level int    `json:"level"`
+	}
+
+	tests := []struct {
+		value    any
+		dataType ipc.DataType
+		data     string
+	}{
+		{"hello", ipc.DATA_TEXT, "hello"},
+		{[]byte{1, 2}, ipc.DATA_BIN, "\x01\x02"},
+		{42, ipc.DATA_INT, "42"},
+		{uint8(7), ipc.DATA_INT, "7"},
+		{rule{"ssh", 3}, ipc.DATA_JSON, `{"name":"ssh","level":3}`},
+		{[]string{"a"}, ipc.DATA_JSON, `["a"]`},
+	}
+	for _, test := range tests {
+		dataType, data, err := ipc.EncodeData(test.value)
+		if err != nil || dataType != test.dataType || string(data) != test.data {
+			t.Errorf("Expected %T as 0x%02x %q, but got 0x%02x %q, %v", test.value, test.dataType, test.data, dataType, data, err)
+		}
+	}
+
+	if _, _, err := ipc.EncodeData(make(chan int)); err == nil {
+		t.Errorf("Expected an error for a channel")
+	}
+}
+
+func TestDecodeData(t *testing.T) {
+	var n int16
+	if err := ipc.DecodeData(ipc.DATA_INT, []byte("-12"), &n); err != nil || n != -12 {
+		t.Errorf("Expected -12, but got %d, %v", n, err)
+	}
+	var s string
+	if err := ipc.DecodeData(ipc.DATA_TEXT, []byte("hello"), &s); err != nil || s != "hello" {
+		t.Errorf("Expected hello, but got %q, %v", s, err)
+	}
+	var addr netip.Addr // encoding.TextUnmarshaler
+	if err := ipc.DecodeData(ipc.DATA_TEXT, []byte("10.0.0.1"), &addr); err != nil || addr.String() != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, but got %v, %v", addr, err)
+	}
+	var m map[string]int
+	if err := ipc.DecodeData(ipc.DATA_YAML, []byte("a: 1\n"), &m); err != nil || m["a"] != 1 {
+		t.Errorf("Expected a: 1, but got %v, %v", m, err)
+	}
+	var v any
+	if err := ipc.DecodeData(ipc.DATA_INT, []byte("5"), &v); err != nil || v != int64(5) {
+		t.Errorf("Expected int64 5, but got %#v, %v", v, err)
+	}
+
+	n = 3
+	if err := ipc.DecodeData(ipc.DATA_INT, []byte("99999"), &n); !errors.Is(err, ipc.ErrDecode) || n != 3 {
+		t.Errorf("Expected ErrDecode for an overflow, and the value kept, but got %d, %v", n, err)
+	}
+	if err := ipc.DecodeData(ipc.DATA_TEXT, []byte("hello"), &n); !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for text into an int, but got %v", err)
+	}
+	if err := ipc.DecodeData(ipc.DATA_JSON, []byte("{"), &m); !errors.Is(err, ipc.ErrDecode) {
+		t.Errorf("Expected ErrDecode for invalid JSON, but got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package ipc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream sends and receives IPC messages on a connection.
+//
+// The encoder and decoder live as long as the connection, so gob only sends the type information
+// with the first message, and bytes buffered by the decoder are not lost between messages.
+// Both sides of a connection must use a Stream (or another persistent encoder/decoder pair) with the same codec.
+//
+// Send and Receive are safe to call from multiple goroutines.
+type Stream struct {
+	conn net.Conn
+
+	wmu sync.Mutex // Serializes the messages written to the connection
+	w   *bufio.Writer
+	enc Encoder
+
+	rmu sync.Mutex // Serializes the messages read from the connection
+	dec Decoder
+}
+
+// NewStream creates a new Stream on the connection, using the codec for the wire format.
+// A nil codec defaults to GobCodec.
+func NewStream(conn net.Conn, codec Codec) *Stream {
+	if codec == nil {
+		codec = GobCodec
+	}
+	w := bufio.NewWriter(conn)
+	return &Stream{
+		conn: conn,
+		w:    w,
+		enc:  codec.NewEncoder(w),
+		dec:  codec.NewDecoder(bufio.NewReader(conn)),
+	}
+}
+
+// Send encodes the message and writes it to the connection
+func (s *Stream) Send(msg *IPCRequest) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if err := s.enc.Encode(msg); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// SendContext is like Send, but gives up when the context is done, through the write deadline of the connection.
+// It returns the context's error if it gave up. The connection is closed then, as the message may be half written.
+func (s *Stream) SendContext(ctx context.Context, msg *IPCRequest) error {
+	if ctx.Done() == nil {
+		return s.Send(msg)
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		s.conn.SetWriteDeadline(time.Now()) // Unblocks the write
+	})
+
+	err := s.enc.Encode(msg)
+	if err == nil {
+		err = s.w.Flush()
+	}
+
+	if !stop() {
+		<-interrupted
+	}
+	if err != nil && ctx.Err() != nil {
+		s.conn.Close()
+		return ctx.Err()
+	}
+	s.conn.SetWriteDeadline(time.Time{})
+	return err
+}
+
+// Receive reads the next message from the connection and decodes it
+func (s *Stream) Receive() (IPCRequest, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+
+	var msg IPCRequest
+	err := s.dec.Decode(&msg)
+	return msg, err
+}
+
+// Conn returns the underlying connection
+func (s *Stream) Conn() net.Conn {
+	return s.conn
+}
+
+// Close closes the underlying connection
+func (s *Stream) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,220 @@
+package ipc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+// connPair returns both ends of a UNIX domain socket connection
+func connPair(tb testing.TB) (net.Conn, net.Conn) {
+	tb.Helper()
+
+	ln, err := net.Listen("unix", filepath.Join(tb.TempDir(), "stream.sock"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			tb.Error(err)
+		}
+		accepted <- c
+	}()
+
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server := <-accepted
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func message(text string) *ipc.IPCRequest {
+	return &ipc.IPCRequest{
+		Header:  ipc.IPCHeader{Identifier: [4]byte{'B', 'E', 'N', 'C'}, MessageType: ipc.MSG_MSG},
+		Message: ipc.IPCMessage{Datatype: ipc.DATA_TEXT, Data: []byte(text), StringData: text},
+	}
+}
+
+// TestStreamManyMessages tests that messages written back to back are all received, in order
+func TestStreamManyMessages(t *testing.T) {
+	const messages = 5000
+
+	for _, codec := range codecs {
+		client, server := connPair(t)
+		sender, receiver := ipc.NewStream(client, codec), ipc.NewStream(server, codec)
+
+		go func() {
+			for i := 0; i < messages; i++ {
+				if err := sender.Send(message(fmt.Sprintf("message %d", i))); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < messages; i++ {
+			msg, err := receiver.Receive()
+			if err != nil {
+				t.Fatalf("%s: failed to receive message %d: %v", codec.Name(), i, err)
+			}
+			if expected := fmt.Sprintf("message %d", i); msg.Message.StringData != expected {
+				t.Fatalf("%s: expected %q, but got %q", codec.Name(), expected, msg.Message.StringData)
+			}
+		}
+	}
+}
+
+var codecs = []ipc.Codec{ipc.GobCodec, ipc.FrameCodec}
+
+// BenchmarkStream measures the throughput of messages sent one way on one connection
+func BenchmarkStream(b *testing.B) {
+	for _, codec := range codecs {
+		b.Run(codec.Name(), func(b *testing.B) {
+			client, server := connPair(b)
+			sender, receiver := ipc.NewStream(client, codec), ipc.NewStream(server, codec)
+			msg := message("a reasonably sized payload for a module, like a row of threat intel")
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(msg.Message.Data)))
+			b.ResetTimer()
+
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if err := sender.Send(msg); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}()
+			for i := 0; i < b.N; i++ {
+				if _, err := receiver.Receive(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStreamRoundTrip measures request/response round trips on one connection
+func BenchmarkStreamRoundTrip(b *testing.B) {
+	for _, codec := range codecs {
+		b.Run(codec.Name(), func(b *testing.B) {
+			client, server := connPair(b)
+			c, s := ipc.NewStream(client, codec), ipc.NewStream(server, codec)
+			msg := message("ping")
+
+			go func() {
+				for {
+					req, err := s.Receive()
+					if err != nil {
+						return
+					}
+					if err := s.Send(&req); err != nil {
+						return
+					}
+				}
+			}()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.Send(msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := c.Receive(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFreshCodecRoundTrip is the baseline for BenchmarkStreamRoundTrip:
+// a new encoder and decoder for every message, sending the type information every time.
+func BenchmarkFreshCodecRoundTrip(b *testing.B) {
+	client, server := connPair(b)
+	msg := message("ping")
+
+	send := func(c net.Conn, msg *ipc.IPCRequest) error {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+			return err
+		}
+		_, err := c.Write(buf.Bytes())
+		return err
+	}
+	receive := func(c net.Conn) (ipc.IPCRequest, error) {
+		var msg ipc.IPCRequest
+		err := gob.NewDecoder(c).Decode(&msg)
+		return msg, err
+	}
+
+	go func() {
+		for {
+			req, err := receive(server)
+			if err != nil {
+				return
+			}
+			if err := send(server, &req); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := send(client, msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := receive(client); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestSendContext tests that a write blocked on a peer that doesn't read gives up when the context is done
+func TestSendContext(t *testing.T) {
+	client, server := net.Pipe() // Writes block until the other end reads
+	defer server.Close()
+	stream := ipc.NewStream(client, nil)
+
+	msg := &ipc.IPCRequest{Header: ipc.IPCHeader{MessageType: ipc.MSG_MSG, MessageId: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := stream.SendContext(ctx, msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, but got %v", err)
+	}
+	if _, err := client.Write([]byte{0}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Expected the connection to be closed after the interrupted write, but got %v", err)
+	}
+
+	client, server = connPair(t)
+	stream = ipc.NewStream(client, nil)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := stream.SendContext(ctx, msg); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if got, err := ipc.NewStream(server, nil).Receive(); err != nil || got.Header != msg.Header {
+		t.Errorf("Expected %+v, but got %+v, %v", msg.Header, got.Header, err)
+	}
+}
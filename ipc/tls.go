@@ -0,0 +1,65 @@
+package ipc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrInvalidCA is returned when a CA file has no PEM encoded certificate
+var ErrInvalidCA = errors.New("ipc: no certificate found in the CA file")
+
+// ServerTLSConfig returns the TLS configuration of a TCP server, with the certificate and key in PEM files.
+// If clientCAFile is set, the clients must present a certificate signed by one of its CAs (mutual TLS).
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13, // Both ends are ours, there is nothing older to support
+	}
+	if clientCAFile != "" {
+		if config.ClientCAs, err = loadCA(clientCAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig returns the TLS configuration of a TCP client, trusting the CAs in the PEM file, or the CAs of the
+// system if caFile is empty. If certFile and keyFile are set, the client presents the certificate to the server (mutual TLS).
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS13}
+	if caFile != "" {
+		var err error
+		if config.RootCAs, err = loadCA(caFile); err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// loadCA returns a pool with the PEM encoded certificates of the file
+func loadCA(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCA, path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,24 @@
+package ipc_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pynezz/pynezzentials/ipc"
+)
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipc.ClientTLSConfig(invalid, "", ""); !errors.Is(err, ipc.ErrInvalidCA) {
+		t.Errorf("Expected ErrInvalidCA, but got %v", err)
+	}
+	if _, err := ipc.ServerTLSConfig(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing certificate to fail, but got %v", err)
+	}
+}
@@ -13,8 +13,10 @@ type IPCRequest struct {
 }
 
 type IPCHeader struct {
-	Identifier  [4]byte // Identifier of the module - available from the IPCClient for qol purposes
-	MessageType byte    // Type of the message
+	Identifier  [4]byte      // Identifier of the module - available from the IPCClient for qol purposes
+	MessageType byte         // Type of the message
+	Flags       byte         // Flags of the message, reserved for the protocol
+	MessageId   IPCMessageId // Identifier of the message, for correlating requests and responses
 }
 
 type IPCMessage struct {
@@ -41,9 +43,9 @@ type IPCResponse struct {
 type GenericData map[string]interface{}
 
 type Metadata struct {
-	Source      string      `json:"source"`      // Source. Ex: sigma
-	Destination Destination `json:"destination"` // Destination. Ex: { name: database, info: "table=threat_intel" }
-	Method      string      `json:"method"`      // Using HTTP verbs to differentiate between requests (ps: this got nothing to do with actual HTTP)
+	Source      string      `json:"source" yaml:"source"`           // Source. Ex: sigma
+	Destination Destination `json:"destination" yaml:"destination"` // Destination. Ex: { name: database, info: "table=threat_intel" }
+	Method      string      `json:"method" yaml:"method"`           // Using HTTP verbs to differentiate between requests (ps: this got nothing to do with actual HTTP)
 }
 
 type Destination struct {
@@ -51,20 +53,21 @@ type Destination struct {
 }
 
 type GetJSON struct {
-	Metadata    Metadata `json:"metadata"`
-	Description string   `json:"description"`
+	Metadata    Metadata `json:"metadata" yaml:"metadata"`
+	Description string   `json:"description" yaml:"description"`
 }
 
 type Object struct {
-	Id       string   `json:"id"`
-	Name     string   `json:"name"`
-	Database Database `json:"database"`
+	Id       string   `json:"id" yaml:"id"`
+	Name     string   `json:"name" yaml:"name"`
+	Info     string   `json:"info" yaml:"info"` // Additional info about the object. Ex: "table=threat_intel"
+	Database Database `json:"database" yaml:"database"`
 }
 
 type Database struct {
-	Name  string `json:"name"`
-	Table string `json:"table"`
-	RowID string `json:"row_id"` // Row ID - fetch anything after this ID
+	Name  string `json:"name" yaml:"name"`
+	Table string `json:"table" yaml:"table"`
+	RowID string `json:"row_id" yaml:"row_id"` // Row ID - fetch anything after this ID
 }
 
 // ----------------------------
@@ -79,8 +82,15 @@ const (
 	MSG_MSG     = 0x04 // Message
 	MSG_MSGACK  = 0x05 // Message acknowledgement
 
-	MSG_PING = 0x08 // Ping message
-	MSG_PONG = 0x09 // Pong message
+	MSG_PING   = 0x08 // Ping message
+	MSG_PONG   = 0x09 // Pong message
+	MSG_CANCEL = 0x0A // Cancel a request in flight, the message id is the id of the request. It is never answered.
+
+	MSG_SUBSCRIBE   = 0x10 // Subscribe to a topic, the topic is the message data
+	MSG_UNSUBSCRIBE = 0x11 // Unsubscribe from a topic, the topic is the message data
+	MSG_PUBLISH     = 0x12 // Publish to a topic, the message data is packed with PackTopic
+
+	MSG_RPC = 0x20 // Call of a named method, the message data is packed with PackMethod
 
 	MSG_DISCONNECT = 0xD1 // Disconnect message
 
@@ -91,25 +101,42 @@ const (
 	MSG_UNKNOWN = 0xFF // Unknown message - for signifying unknown type, maybe an error, but the receiver will try to wing it
 )
 
+const (
+	FLAG_REPLY = 0x01 // Set on responses, which carry the message id of the request they answer
+)
+
+// PROTOCOL_VERSION is the version of the IPC protocol, announced by the client in the MSG_CONN handshake
+const PROTOCOL_VERSION = 1
+
 const (
 	DATA_TEXT = 0x01 // Text data
 	DATA_INT  = 0x02 // Integer data
 	DATA_JSON = 0x03 // JSON data	(used for structured data)
 	DATA_YAML = 0x04 // YAML data	(used for configuration files)
 	DATA_BIN  = 0x05 // Binary data	(such as images, files, etc.)
+
+	DATA_MSGPACK = 0x06 // MessagePack data
+	DATA_CBOR    = 0x07 // CBOR data
+
+	DATA_CUSTOM = 0x80 // First datatype left to applications, see RegisterDataCodec
 )
 
 var MSGTYPE = map[string]byte{
-	"conn":       byte(MSG_CONN),
-	"ack":        byte(MSG_ACK),
-	"connack":    byte(MSG_CONNACK),
-	"msg":        byte(MSG_MSG),
-	"msgack":     byte(MSG_MSGACK),
-	"ping":       byte(MSG_PING),
-	"pong":       byte(MSG_PONG),
-	"disconnect": byte(MSG_DISCONNECT),
-	"error":      byte(MSG_ERROR),
-	"unknown":    byte(MSG_UNKNOWN),
+	"conn":        byte(MSG_CONN),
+	"ack":         byte(MSG_ACK),
+	"connack":     byte(MSG_CONNACK),
+	"msg":         byte(MSG_MSG),
+	"msgack":      byte(MSG_MSGACK),
+	"ping":        byte(MSG_PING),
+	"pong":        byte(MSG_PONG),
+	"cancel":      byte(MSG_CANCEL),
+	"subscribe":   byte(MSG_SUBSCRIBE),
+	"unsubscribe": byte(MSG_UNSUBSCRIBE),
+	"publish":     byte(MSG_PUBLISH),
+	"rpc":         byte(MSG_RPC),
+	"disconnect":  byte(MSG_DISCONNECT),
+	"error":       byte(MSG_ERROR),
+	"unknown":     byte(MSG_UNKNOWN),
 }
 
 var IDENTIFIERS = map[string][4]byte{}